@@ -1,10 +1,29 @@
 import { createEffect, createSignal, onCleanup, onMount } from "solid-js";
 import { createStore } from "solid-js/store";
+import {
+  PROTOCOL_VERSION,
+  type ChecklistMessage,
+  type Request,
+  type ServerMessage,
+  type StaminaMessage,
+  type TodoItem,
+} from "./protocol";
 
 const RECONNECT_DELAY = 5000;
 
+const DISPLAY_NAMES: Record<string, string> = {
+  bh3: "Honkai 3rd",
+  nxx: "Tears of Themis",
+};
+
+export type { TodoItem };
+
 export const useZbservSocket = (address: string) => {
-  const [store, setStore] = createStore({
+  const [store, setStore] = createStore<{
+    connected: boolean;
+    status: Record<string, { display: string; curr: number; max: number }>;
+    checklist: Record<string, TodoItem[]>;
+  }>({
     connected: false,
     status: {
       genshin: {
@@ -23,6 +42,7 @@ export const useZbservSocket = (address: string) => {
         max: 0,
       },
     },
+    checklist: {},
   });
 
   const [conn, setConn] = createSignal<WebSocket | null>(null);
@@ -35,11 +55,35 @@ export const useZbservSocket = (address: string) => {
 
     const handleMessage = ({ data }: MessageEvent) => {
       try {
-        const msg = JSON.parse(data);
-        if (msg.details) return;
+        const msg: ServerMessage = JSON.parse(data);
+        if (msg.type === "hello") {
+          if (msg.payload.protocol !== PROTOCOL_VERSION) {
+            console.warn(
+              `zbserv speaks protocol ${msg.payload.protocol}, expected ${PROTOCOL_VERSION}`,
+            );
+          }
+          return;
+        }
+        if (msg.type === "response") {
+          if (msg.error) {
+            console.warn(`zbserv request ${msg.id} failed: ${msg.error.message}`);
+          }
+          return;
+        }
+        if (msg.topic.startsWith("checklist.")) {
+          const { payload } = msg as ChecklistMessage;
+          setStore("checklist", payload.game, payload.items ?? []);
+          return;
+        }
+        if (!msg.topic.startsWith("stamina.")) return;
 
-        const game = msg.game;
-        setStore("status", game, { curr: msg.curr, max: msg.max });
+        const { payload } = msg as StaminaMessage;
+        const game = payload.game;
+        setStore("status", game, (s) => ({
+          display: s?.display ?? DISPLAY_NAMES[game] ?? game,
+          curr: payload.curr,
+          max: payload.max,
+        }));
       } catch (e) {
         console.error("Failed to parse message", e);
       }
@@ -47,6 +91,12 @@ export const useZbservSocket = (address: string) => {
 
     const handleOpen = () => {
       setStore("connected", true);
+      const subscribe: Request<"subscribe"> = {
+        id: "subscribe",
+        method: "subscribe",
+        params: { topics: ["stamina", "checklist"] },
+      };
+      c.send(JSON.stringify(subscribe));
     };
 
     const handleError = () => {
@@ -22,7 +22,11 @@ function App() {
 
   providers.onOutput((outputMap) => setOutput(outputMap));
 
-  const zbstore = useZbservSocket("ws://localhost:45456/ws");
+  // needed once zbserv has API keys configured
+  const key = import.meta.env.VITE_ZBSERV_KEY;
+  const zbstore = useZbservSocket(
+    "ws://localhost:45456/ws" + (key ? `?key=${encodeURIComponent(key)}` : ""),
+  );
 
   return (
     <div class="flex flex-row h-full w-full items-center justify-end overflow-clip text-center text-foreground">
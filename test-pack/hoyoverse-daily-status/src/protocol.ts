@@ -0,0 +1,259 @@
+// Code generated by `zbserv protocol`. DO NOT EDIT.
+
+export const PROTOCOL_VERSION = 3;
+
+export interface HelloPayload {
+  protocol: number;
+  server: string;
+  topics: string[] | null;
+  commands: string[] | null;
+}
+
+export interface StaminaPayload {
+  game: string;
+  curr: number;
+  max: number;
+}
+
+export interface TodoItem {
+  id: string;
+  game: string;
+  name: string;
+  done: number;
+  total: number;
+  complete: boolean;
+  reset: string;
+  resetsAt?: number;
+}
+
+export interface ChecklistPayload {
+  game: string;
+  items: TodoItem[] | null;
+  dailyReset: number;
+  weeklyReset: number;
+}
+
+export interface EndgameMode {
+  id: string;
+  game: string;
+  name: string;
+  stars: number;
+  maxStars: number;
+  endsAt: number;
+  resetsIn: number;
+}
+
+export interface EndgamePayload {
+  game: string;
+  modes: EndgameMode[] | null;
+}
+
+export interface CheckInReward {
+  name: string;
+  count: number;
+  icon: string;
+}
+
+export interface CheckInResult {
+  game: string;
+  status: string;
+  reward?: CheckInReward;
+  totalDays: number;
+  message?: string;
+  time: number;
+}
+
+export interface RedeemResult {
+  game: string;
+  uid: string;
+  status: string;
+  message?: string;
+}
+
+export interface RedeemPayload {
+  game: string;
+  code: string;
+  results: RedeemResult[] | null;
+}
+
+export interface ProcessPayload {
+  name: string;
+  pid: string;
+  game?: string;
+  running: boolean;
+}
+
+export interface VerificationAlert {
+  kind: string;
+  id: string;
+  game: string;
+  uid: string;
+  url: string;
+  gt?: string;
+  challenge?: string;
+  since: number;
+}
+
+export interface CookieAlert {
+  kind: string;
+  id: string;
+  region: string;
+  expiresAt?: number;
+  message: string;
+}
+
+export interface AlertAck {
+  kind: string;
+  id: string;
+}
+
+export interface MediaSourceStatus {
+  kind: string;
+}
+
+export interface RPCError {
+  code: number;
+  message: string;
+}
+
+export interface AlertAckParams {
+  id: string;
+}
+
+export interface CaptchaSolveParams {
+  game: string;
+  challenge?: string;
+  validate?: string;
+  seccode?: string;
+}
+
+export interface GameParams {
+  game: string;
+}
+
+export interface RedeemParams {
+  game: string;
+  code: string;
+}
+
+export interface TopicsParams {
+  topics: string[] | null;
+}
+
+export interface HelloMessage {
+  type: "hello";
+  /** monotonic, not contiguous: messages sent to other clients take seqs too */
+  seq: number;
+  ts: number;
+  payload: HelloPayload;
+}
+
+export interface StaminaMessage {
+  type: "event";
+  topic: `stamina.${string}`;
+  /** monotonic, not contiguous: messages sent to other clients take seqs too */
+  seq: number;
+  ts: number;
+  payload: StaminaPayload;
+}
+
+export interface ChecklistMessage {
+  type: "event";
+  topic: `checklist.${string}`;
+  /** monotonic, not contiguous: messages sent to other clients take seqs too */
+  seq: number;
+  ts: number;
+  payload: ChecklistPayload;
+}
+
+export interface EndgameMessage {
+  type: "event";
+  topic: `endgame.${string}`;
+  /** monotonic, not contiguous: messages sent to other clients take seqs too */
+  seq: number;
+  ts: number;
+  payload: EndgamePayload;
+}
+
+export interface CheckInMessage {
+  type: "event";
+  topic: "checkin";
+  /** monotonic, not contiguous: messages sent to other clients take seqs too */
+  seq: number;
+  ts: number;
+  payload: CheckInResult;
+}
+
+export interface RedeemMessage {
+  type: "event";
+  topic: "redeem";
+  /** monotonic, not contiguous: messages sent to other clients take seqs too */
+  seq: number;
+  ts: number;
+  payload: RedeemPayload;
+}
+
+export interface ProcessMessage {
+  type: "event";
+  topic: "process";
+  /** monotonic, not contiguous: messages sent to other clients take seqs too */
+  seq: number;
+  ts: number;
+  payload: ProcessPayload;
+}
+
+export interface AlertMessage {
+  type: "event";
+  topic: "alerts";
+  /** monotonic, not contiguous: messages sent to other clients take seqs too */
+  seq: number;
+  ts: number;
+  payload: VerificationAlert | CookieAlert | AlertAck;
+}
+
+export interface MediaMessage {
+  type: "event";
+  topic: "media";
+  /** monotonic, not contiguous: messages sent to other clients take seqs too */
+  seq: number;
+  ts: number;
+  payload: unknown | MediaSourceStatus;
+}
+
+export interface ResponseMessage {
+  type: "response";
+  id: string;
+  /** monotonic, not contiguous: messages sent to other clients take seqs too */
+  seq: number;
+  ts: number;
+  payload: unknown;
+  error?: RPCError;
+}
+
+export type ServerMessage =
+  | HelloMessage
+  | StaminaMessage
+  | ChecklistMessage
+  | EndgameMessage
+  | CheckInMessage
+  | RedeemMessage
+  | ProcessMessage
+  | AlertMessage
+  | MediaMessage
+  | ResponseMessage;
+
+export interface CommandParams {
+  "alert.ack": AlertAckParams;
+  "captcha.solve": CaptchaSolveParams;
+  "game.refresh": GameParams;
+  "media.toggle": Record<string, never>;
+  "redeem": RedeemParams;
+  "subscribe": TopicsParams;
+  "unsubscribe": TopicsParams;
+}
+
+export interface Request<M extends keyof CommandParams = keyof CommandParams> {
+  id: string;
+  method: M;
+  params?: CommandParams[M];
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+const AlertAcknowledged = "acknowledged"
+
+// AlertAck tells clients an alert was dismissed by one of them.
+type AlertAck struct {
+	Kind string `json:"kind"`
+	Id   string `json:"id"`
+}
+
+// Alerts remembers which standing alerts were acknowledged, so they are not
+// replayed to every widget that subscribes until the alert is raised again.
+type Alerts struct {
+	mu      sync.Mutex
+	acked   map[string]bool
+	publish func(topic string, data []byte)
+}
+
+var alerts = NewAlerts()
+
+func NewAlerts() *Alerts {
+	return &Alerts{acked: make(map[string]bool)}
+}
+
+func (a *Alerts) SetPublisher(publish func(topic string, data []byte)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.publish = publish
+}
+
+// Raised clears an earlier acknowledgement once the alert comes back.
+func (a *Alerts) Raised(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.acked, id)
+}
+
+// Ack dismisses a standing alert, reporting false if there is none by id.
+func (a *Alerts) Ack(id string) bool {
+	found := false
+	for _, alert := range standingAlerts() {
+		if alertId(alert) == id {
+			found = true
+		}
+	}
+	if !found {
+		return false
+	}
+
+	a.mu.Lock()
+	a.acked[id] = true
+	publish := a.publish
+	a.mu.Unlock()
+
+	if publish == nil {
+		return true
+	}
+	data, err := encodeEvent(TopicAlerts, AlertAck{Kind: AlertAcknowledged, Id: id})
+	if err != nil {
+		log.Println(err)
+		return true
+	}
+	publish(TopicAlerts, data)
+	return true
+}
+
+func (a *Alerts) acknowledged(id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.acked[id]
+}
+
+func standingAlerts() []any {
+	var standing []any
+	for _, v := range captchas.Pending() {
+		standing = append(standing, verificationAlert(AlertVerificationRequired, v))
+	}
+	for _, region := range []Region{RegionOverseas, RegionChina} {
+		if c, ok := credentials[region]; ok {
+			if alert := c.Alert(); alert != nil {
+				standing = append(standing, *alert)
+			}
+		}
+	}
+	return standing
+}
+
+func alertId(alert any) string {
+	switch alert := alert.(type) {
+	case VerificationAlert:
+		return alert.Id
+	case CookieAlert:
+		return alert.Id
+	}
+	return ""
+}
+
+// Snapshot is every alert still standing and not acknowledged, for clients
+// subscribing to the alerts topic.
+func (a *Alerts) Snapshot(string) [][]byte {
+	var messages [][]byte
+	for _, alert := range standingAlerts() {
+		if a.acknowledged(alertId(alert)) {
+			continue
+		}
+		data, err := encodeEvent(TopicAlerts, alert)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		messages = append(messages, data)
+	}
+	return messages
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"expvar"
+	"log"
+	"net/http"
+	"time"
+)
+
+// openapi.json is generated from apiRoutes by `go generate`
+//
+//go:embed openapi.json
+var openAPIDoc []byte
+
+// API answers plain HTTP requests with the state the websocket pushes, for
+// scripts and status bars that do not want to hold a connection open.
+type API struct {
+	serv    *Server
+	updater *ResinUpdater
+	monitor *Monitor
+	started time.Time
+}
+
+type apiRoute struct {
+	method  string
+	path    string
+	summary string
+	// the 200 response body
+	response any
+	// other statuses the route answers with, by description
+	statuses map[int]string
+	handle   func(a *API, w http.ResponseWriter, r *http.Request)
+}
+
+var apiRoutes = []apiRoute{
+	{
+		method:   "GET",
+		path:     "/api/health",
+		summary:  "Whether zbserv is up and how many alerts need attention",
+		response: HealthPayload{},
+		handle:   (*API).health,
+	},
+	{
+		method:   "GET",
+		path:     "/api/games",
+		summary:  "Last known state of every configured game",
+		response: []GameState{},
+		handle:   (*API).games,
+	},
+	{
+		method:   "GET",
+		path:     "/api/games/{id}/stamina",
+		summary:  "Last known stamina of one game",
+		response: StaminaPayload{},
+		statuses: map[int]string{
+			http.StatusNotFound:           "the game is unknown or has no account configured",
+			http.StatusServiceUnavailable: "the game has not been fetched yet",
+		},
+		handle: (*API).stamina,
+	},
+	{
+		method:   "GET",
+		path:     "/api/media/now-playing",
+		summary:  "What the media source last reported, as sent by the extension",
+		response: json.RawMessage{},
+		statuses: map[int]string{
+			http.StatusNoContent: "nothing has been reported since zbserv started",
+		},
+		handle: (*API).nowPlaying,
+	},
+	{
+		method:   "GET",
+		path:     "/api/processes",
+		summary:  "Game processes running right now",
+		response: []ProcessPayload{},
+		handle:   (*API).processes,
+	},
+	{
+		method:   "GET",
+		path:     "/api/checklist",
+		summary:  "Every account's daily checklist as last fetched, accounts not fetched yet are listed in errors",
+		response: Checklist{},
+		handle:   (*API).checklist,
+	},
+	{
+		method:   "GET",
+		path:     "/api/resets",
+		summary:  "Next daily and weekly reset of every account's server",
+		response: []ResetTimes{},
+		handle:   func(_ *API, w http.ResponseWriter, r *http.Request) { serveResets(w, r) },
+	},
+	{
+		method:   "GET",
+		path:     "/api/endgame",
+		summary:  "Every account's endgame progress as last fetched, accounts not fetched yet are listed in errors",
+		response: EndgameResponse{},
+		handle:   (*API).endgame,
+	},
+	{
+		method:   "GET",
+		path:     "/api/openapi.json",
+		summary:  "This document",
+		response: json.RawMessage{},
+		handle:   (*API).openAPI,
+	},
+}
+
+func (a *API) Register(mux *http.ServeMux) {
+	for _, route := range apiRoutes {
+		handle := route.handle
+		mux.HandleFunc(route.method+" "+route.path, func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := auth.Authorize(w, r, ScopeRead); !ok {
+				return
+			}
+			handle(a, w, r)
+		})
+	}
+
+	// request counters from client.go, not part of the documented API
+	mux.HandleFunc("GET /debug/vars", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := auth.Authorize(w, r, ScopeRead); !ok {
+			return
+		}
+		expvar.Handler().ServeHTTP(w, r)
+	})
+}
+
+type ErrorBody struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, ErrorBody{Error: message})
+}
+
+type HealthPayload struct {
+	// "ok", or "degraded" while an alert is standing
+	Status      string `json:"status"`
+	Protocol    int    `json:"protocol"`
+	Uptime      int64  `json:"uptime"`
+	Clients     int    `json:"clients"`
+	MediaSource bool   `json:"mediaSource"`
+	Alerts      int    `json:"alerts"`
+}
+
+func (a *API) health(w http.ResponseWriter, r *http.Request) {
+	health := HealthPayload{
+		Status:      "ok",
+		Protocol:    ProtocolVersion,
+		Uptime:      int64(time.Since(a.started).Seconds()),
+		Clients:     a.serv.ClientCount(),
+		MediaSource: a.serv.MediaConnected(),
+		Alerts:      len(standingAlerts()),
+	}
+	if health.Alerts > 0 {
+		health.Status = "degraded"
+	}
+	writeJSON(w, http.StatusOK, health)
+}
+
+func (a *API) games(w http.ResponseWriter, r *http.Request) {
+	games := []GameState{}
+	for _, config := range Configs() {
+		games = append(games, a.updater.State(config))
+	}
+	writeJSON(w, http.StatusOK, games)
+}
+
+func (a *API) stamina(w http.ResponseWriter, r *http.Request) {
+	game := GameId(r.PathValue("id"))
+	accounts := accountsFor(game)
+	if len(accounts) == 0 {
+		writeAPIError(w, http.StatusNotFound, "no account configured for "+game)
+		return
+	}
+
+	state := a.updater.State(accounts[0])
+	if state.Stamina == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, game+" has not been fetched yet")
+		return
+	}
+	writeJSON(w, http.StatusOK, state.Stamina)
+}
+
+// errNotFetched explains why a game is missing from the checklist or
+// endgame, which only hold what the updater has already fetched.
+func errNotFetched(state GameState) string {
+	if state.Verification != nil {
+		return "paused until the captcha is solved"
+	}
+	return "not fetched yet"
+}
+
+func (a *API) checklist(w http.ResponseWriter, r *http.Request) {
+	list := Checklist{Items: []TodoItem{}}
+	for _, config := range Configs() {
+		state := a.updater.State(config)
+		if state.Checklist == nil {
+			if list.Errors == nil {
+				list.Errors = map[GameId]string{}
+			}
+			list.Errors[config.game] = errNotFetched(state)
+			continue
+		}
+		list.Items = append(list.Items, state.Checklist.Items...)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (a *API) endgame(w http.ResponseWriter, r *http.Request) {
+	resp := EndgameResponse{Modes: []EndgameMode{}}
+	for _, config := range Configs() {
+		state := a.updater.State(config)
+		if state.Endgame == nil {
+			if resp.Errors == nil {
+				resp.Errors = map[GameId]string{}
+			}
+			resp.Errors[config.game] = errNotFetched(state)
+			continue
+		}
+		resp.Modes = append(resp.Modes, state.Endgame...)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (a *API) nowPlaying(w http.ResponseWriter, r *http.Request) {
+	media, ok := a.serv.NowPlaying()
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, media)
+}
+
+func (a *API) processes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.monitor.Processes())
+}
+
+func (a *API) openAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDoc)
+}
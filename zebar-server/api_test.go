@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAPI(t *testing.T) (*API, *httptest.Server) {
+	t.Helper()
+	withCaptchaGate(t)
+
+	serv := NewServer()
+	api := &API{
+		serv:    serv,
+		updater: NewResinUpdater(t.Context(), serv),
+		monitor: NewMonitor(t.Context()),
+		started: time.Now().Add(-time.Minute),
+	}
+
+	mux := http.NewServeMux()
+	api.Register(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return api, srv
+}
+
+func get(t *testing.T, url string, v any) int {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != nil && len(b) > 0 {
+		if err := json.Unmarshal(b, v); err != nil {
+			t.Fatalf("%s: %v", b, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAPIGames(t *testing.T) {
+	api, srv := newTestAPI(t)
+	api.updater.notes[GENSHIN] = DailyNoteCommon{Game: GENSHIN, Current: 150, Max: 200}
+
+	var games []GameState
+	if status := get(t, srv.URL+"/api/games", &games); status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	if len(games) != len(Configs()) {
+		t.Fatalf("%d games, want %d", len(games), len(Configs()))
+	}
+	for _, game := range games {
+		if game.Game == GENSHIN && (game.Stamina == nil || game.Stamina.Curr != 150 || game.Checklist == nil) {
+			t.Errorf("genshin = %+v", game)
+		}
+		if game.Game != GENSHIN && game.Stamina != nil {
+			t.Errorf("%s has stamina before being fetched", game.Game)
+		}
+	}
+}
+
+func TestAPIStamina(t *testing.T) {
+	api, srv := newTestAPI(t)
+
+	var body ErrorBody
+	if status := get(t, srv.URL+"/api/games/genshin/stamina", &body); status != http.StatusServiceUnavailable || body.Error == "" {
+		t.Errorf("before fetching: status %d, body %+v", status, body)
+	}
+	if status := get(t, srv.URL+"/api/games/wuwa/stamina", nil); status != http.StatusNotFound {
+		t.Errorf("unknown game: status %d", status)
+	}
+
+	api.updater.notes[GENSHIN] = DailyNoteCommon{Game: GENSHIN, Current: 150, Max: 200}
+
+	var stamina StaminaPayload
+	if status := get(t, srv.URL+"/api/games/genshin/stamina", &stamina); status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	if stamina != (StaminaPayload{Game: GENSHIN, Curr: 150, Max: 200}) {
+		t.Errorf("stamina = %+v", stamina)
+	}
+}
+
+func TestAPIChecklistAndEndgame(t *testing.T) {
+	calls := withCountingRecords(t, 0)
+	api, srv := newTestAPI(t)
+
+	todo := newTodo(GENSHIN, "commissions", "Daily Commissions", 4, 4, ResetDaily)
+	api.updater.notes[GENSHIN] = DailyNoteCommon{Game: GENSHIN, Server: serverGenshin, Todos: []TodoItem{todo}}
+	api.updater.endgame[GENSHIN] = []EndgameMode{{Id: "genshin.spiral_abyss", Game: GENSHIN}}
+	captchas.pending[accountKey(StarRailConfig)] = Verification{Game: STARRAIL, Uid: StarRailConfig.uid}
+
+	var list Checklist
+	if status := get(t, srv.URL+"/api/checklist", &list); status != http.StatusOK {
+		t.Fatalf("checklist status %d", status)
+	}
+	if len(list.Items) != 1 || list.Items[0].Id != todo.Id {
+		t.Errorf("items = %+v", list.Items)
+	}
+	if list.Errors[STARRAIL] != "paused until the captcha is solved" || list.Errors[ZZZ] != "not fetched yet" {
+		t.Errorf("errors = %v", list.Errors)
+	}
+
+	var endgame EndgameResponse
+	if status := get(t, srv.URL+"/api/endgame", &endgame); status != http.StatusOK {
+		t.Fatalf("endgame status %d", status)
+	}
+	if len(endgame.Modes) != 1 || endgame.Modes[0].Id != "genshin.spiral_abyss" {
+		t.Errorf("modes = %+v", endgame.Modes)
+	}
+	if _, ok := endgame.Errors[GENSHIN]; ok || len(endgame.Errors) != len(Configs())-1 {
+		t.Errorf("errors = %v", endgame.Errors)
+	}
+
+	// both are served from what the updater already has
+	if n := calls.Load(); n != 0 {
+		t.Errorf("%d requests reached HoYoLAB", n)
+	}
+}
+
+func TestAPINowPlaying(t *testing.T) {
+	api, srv := newTestAPI(t)
+
+	if status := get(t, srv.URL+"/api/media/now-playing", nil); status != http.StatusNoContent {
+		t.Errorf("status %d before anything played", status)
+	}
+
+	api.serv.Broadcast(json.RawMessage(`{"title":"song"}`))
+
+	var media map[string]string
+	if status := get(t, srv.URL+"/api/media/now-playing", &media); status != http.StatusOK || media["title"] != "song" {
+		t.Errorf("status %d, media %v", status, media)
+	}
+}
+
+func TestAPIProcessesAndHealth(t *testing.T) {
+	api, srv := newTestAPI(t)
+	api.monitor.running["42"] = MonitorEvent{Name: GenshinProcess, Pid: "42", Type: StartEvent}
+
+	var processes []ProcessPayload
+	get(t, srv.URL+"/api/processes", &processes)
+	if len(processes) != 1 || processes[0].Game != GENSHIN || !processes[0].Running {
+		t.Errorf("processes = %+v", processes)
+	}
+
+	var health HealthPayload
+	if status := get(t, srv.URL+"/api/health", &health); status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	if health.Status != "ok" || health.Protocol != ProtocolVersion || health.Uptime < 60 {
+		t.Errorf("health = %+v", health)
+	}
+
+	captchas.pending[accountKey(GenshinConfig)] = Verification{Game: GENSHIN, Uid: GenshinConfig.uid}
+	get(t, srv.URL+"/api/health", &health)
+	if health.Status != "degraded" || health.Alerts != 1 {
+		t.Errorf("health with a pending captcha = %+v", health)
+	}
+}
+
+func TestAPIIsReadOnly(t *testing.T) {
+	_, srv := newTestAPI(t)
+
+	resp, err := http.Post(srv.URL+"/api/games", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST status %d", resp.StatusCode)
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	_, srv := newTestAPI(t)
+
+	var doc struct {
+		OpenAPI string                    `json:"openapi"`
+		Paths   map[string]map[string]any `json:"paths"`
+	}
+	if status := get(t, srv.URL+"/api/openapi.json", &doc); status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	if doc.OpenAPI == "" {
+		t.Error("no openapi version")
+	}
+	for _, route := range apiRoutes {
+		if doc.Paths[route.path][strings.ToLower(route.method)] == nil {
+			t.Errorf("%s %s is not documented", route.method, route.path)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Scope is what a client is allowed to do.
+type Scope int
+
+const (
+	ScopeNone Scope = iota
+	// events, snapshots and the REST API
+	ScopeRead
+	// also commands that change something, such as redeeming a code
+	ScopeControl
+)
+
+func (s Scope) String() string {
+	switch s {
+	case ScopeRead:
+		return "read"
+	case ScopeControl:
+		return "control"
+	}
+	return "none"
+}
+
+func parseScope(s string) (Scope, error) {
+	switch s {
+	case "", "read":
+		return ScopeRead, nil
+	case "control":
+		return ScopeControl, nil
+	}
+	return ScopeNone, fmt.Errorf("unknown scope %q, want read or control", s)
+}
+
+// Auth decides who may talk to zbserv. Browsers are only let in from
+// allowed origins, so a random page cannot reach the server on localhost.
+// Once API keys are configured every client needs one, and the media source
+// needs its own key which is good for nothing else.
+type Auth struct {
+	// allowed on top of localhost
+	origins  []string
+	keys     map[string]Scope
+	mediaKey string
+}
+
+var auth = NewAuth()
+
+// shorter keys are easy to guess, and too short for registerSecret to redact
+const minKeyLength = 16
+
+func NewAuth() *Auth {
+	return &Auth{keys: map[string]Scope{}}
+}
+
+// loadAuth reads ALLOWED_ORIGINS, MEDIA_SOURCE_KEY and any number of
+// API_KEY_<name> entries, each with an optional API_KEY_<name>_SCOPE.
+func loadAuth(env map[string]string) (*Auth, error) {
+	a := NewAuth()
+
+	for _, origin := range strings.Split(env["ALLOWED_ORIGINS"], ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			a.origins = append(a.origins, strings.TrimSuffix(origin, "/"))
+		}
+	}
+
+	var err error
+	if a.mediaKey, err = lookupSecret(env, "MEDIA_SOURCE_KEY"); err != nil {
+		return nil, err
+	}
+	if a.mediaKey != "" && len(a.mediaKey) < minKeyLength {
+		return nil, fmt.Errorf("MEDIA_SOURCE_KEY: keys need at least %d characters", minKeyLength)
+	}
+
+	// a key set with API_KEY_<name>_SOURCE is resolved by lookupSecret, which
+	// should only run once per key, a cmd: source runs a program every time
+	names := map[string]bool{}
+	for name := range env {
+		if strings.HasPrefix(name, "API_KEY_") && !strings.HasSuffix(name, "_SCOPE") {
+			names[strings.TrimSuffix(name, "_SOURCE")] = true
+		}
+	}
+
+	for name := range names {
+		key, err := lookupSecret(env, name)
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			continue
+		}
+		if len(key) < minKeyLength {
+			return nil, fmt.Errorf("%s: keys need at least %d characters", name, minKeyLength)
+		}
+		scope, err := parseScope(env[name+"_SCOPE"])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if key == a.mediaKey {
+			return nil, fmt.Errorf("%s: the media source key cannot be an API key too", name)
+		}
+		a.keys[key] = scope
+	}
+
+	// otherwise anyone who gets past the origin check could pose as the
+	// music player
+	if len(a.keys) > 0 && a.mediaKey == "" {
+		return nil, errors.New("MEDIA_SOURCE_KEY is required once API keys are configured")
+	}
+	return a, nil
+}
+
+// AllowOrigin reports whether a browser on r's origin may connect. Requests
+// without one do not come from a web page.
+func (a *Auth) AllowOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || slices.Contains(a.origins, origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// requestKey takes the key from an Authorization: Bearer header, or from
+// ?key= since browsers cannot set headers on websockets and EventSource.
+func requestKey(r *http.Request) string {
+	if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return key
+	}
+	return r.URL.Query().Get("key")
+}
+
+func matchKey(key, want string) bool {
+	return key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(want)) == 1
+}
+
+// Scope is what r is allowed to do. Without any API keys configured every
+// client from an allowed origin gets full control, as before keys existed,
+// and so does anyone the Unix socket's file mode lets in.
+func (a *Auth) Scope(r *http.Request) Scope {
+	if !a.AllowOrigin(r) {
+		return ScopeNone
+	}
+	if len(a.keys) == 0 || localSocket(r) {
+		return ScopeControl
+	}
+
+	key := requestKey(r)
+	scope := ScopeNone
+	for k, s := range a.keys {
+		if matchKey(key, k) {
+			scope = s
+		}
+	}
+	return scope
+}
+
+// Authorize answers r with an error unless it is allowed at least need,
+// returning what it is allowed.
+func (a *Auth) Authorize(w http.ResponseWriter, r *http.Request, need Scope) (Scope, bool) {
+	if !a.AllowOrigin(r) {
+		log.Println("rejected origin", r.Header.Get("Origin"))
+		writeAPIError(w, http.StatusForbidden, "origin not allowed")
+		return ScopeNone, false
+	}
+
+	scope := a.Scope(r)
+	switch {
+	case scope == ScopeNone:
+		writeAPIError(w, http.StatusUnauthorized, "missing or unknown API key")
+		return scope, false
+	case scope < need:
+		writeAPIError(w, http.StatusForbidden, "API key needs "+need.String()+" access")
+		return scope, false
+	}
+	return scope, true
+}
+
+// AuthorizeMediaSource answers r with an error unless it carries the media
+// source key or came in over the Unix socket. Without API keys a media source
+// key is optional. API keys do not count.
+func (a *Auth) AuthorizeMediaSource(w http.ResponseWriter, r *http.Request) bool {
+	if !a.AllowOrigin(r) {
+		log.Println("rejected media source origin", r.Header.Get("Origin"))
+		writeAPIError(w, http.StatusForbidden, "origin not allowed")
+		return false
+	}
+	needKey := a.mediaKey != "" || !a.Open()
+	if needKey && !localSocket(r) && !matchKey(requestKey(r), a.mediaKey) {
+		writeAPIError(w, http.StatusUnauthorized, "missing or wrong media source key")
+		return false
+	}
+	return true
+}
+
+// Open reports whether anyone on an allowed origin has full control.
+func (a *Auth) Open() bool {
+	return len(a.keys) == 0
+}
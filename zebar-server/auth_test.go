@@ -0,0 +1,251 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func withAuth(t *testing.T, a *Auth) {
+	t.Helper()
+
+	old := auth
+	auth = a
+	t.Cleanup(func() { auth = old })
+}
+
+func testAuth(t *testing.T) *Auth {
+	t.Helper()
+
+	a, err := loadAuth(map[string]string{
+		"ALLOWED_ORIGINS":      "https://widgets.example.com, https://other.example.com/",
+		"MEDIA_SOURCE_KEY":     "media-secret-key",
+		"API_KEY_WIDGET":       "widget-secret-key",
+		"API_KEY_WIDGET_SCOPE": "control",
+		"API_KEY_PHONE":        "phone-secret-key",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAllowOrigin(t *testing.T) {
+	a := testAuth(t)
+
+	tests := map[string]bool{
+		"":                            true,
+		"http://localhost:5173":       true,
+		"http://asset.localhost":      true,
+		"http://127.0.0.1:8080":       true,
+		"http://[::1]":                true,
+		"https://widgets.example.com": true,
+		"https://other.example.com":   true,
+		"https://evil.example.com":    false,
+		"http://localhost.evil.com":   false,
+		"null":                        false,
+	}
+	for origin, want := range tests {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if got := a.AllowOrigin(r); got != want {
+			t.Errorf("AllowOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+}
+
+func TestAuthScope(t *testing.T) {
+	a := testAuth(t)
+
+	tests := []struct {
+		header, query string
+		want          Scope
+	}{
+		{"", "", ScopeNone},
+		{"Bearer widget-secret-key", "", ScopeControl},
+		{"", "widget-secret-key", ScopeControl},
+		{"Bearer phone-secret-key", "", ScopeRead},
+		{"Bearer wrong", "", ScopeNone},
+		// the media source key is not an API key
+		{"Bearer media-secret-key", "", ScopeNone},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/health?key="+tt.query, nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		if got := a.Scope(r); got != tt.want {
+			t.Errorf("%q %q: scope %v, want %v", tt.header, tt.query, got, tt.want)
+		}
+	}
+
+	if got := NewAuth().Scope(httptest.NewRequest("GET", "/", nil)); got != ScopeControl {
+		t.Errorf("without keys: scope %v, want control", got)
+	}
+}
+
+func TestLoadAuthErrors(t *testing.T) {
+	tests := map[string]map[string]string{
+		"bad scope":       {"API_KEY_A": "a-secret-long-enough", "API_KEY_A_SCOPE": "admin", "MEDIA_SOURCE_KEY": "media-secret-key"},
+		"media key":       {"API_KEY_A": "shared-secret-key", "MEDIA_SOURCE_KEY": "shared-secret-key"},
+		"short key":       {"API_KEY_A": "short", "MEDIA_SOURCE_KEY": "media-secret-key"},
+		"no media key":    {"API_KEY_A": "a-secret-long-enough"},
+		"short media key": {"MEDIA_SOURCE_KEY": "short"},
+	}
+	for name, env := range tests {
+		if _, err := loadAuth(env); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestLoadAuthSecretSource(t *testing.T) {
+	t.Setenv("ZBSERV_PASSPHRASE", "hunter2")
+	passphraseOnce = sync.Once{}
+	t.Cleanup(func() { passphraseOnce = sync.Once{} })
+
+	sealed, err := encryptSecret([]byte("sealed-secret-key"), "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.enc")
+	if err := os.WriteFile(path, sealed, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := loadAuth(map[string]string{
+		"API_KEY_PHONE_SOURCE": "enc:" + path,
+		"API_KEY_PHONE_SCOPE":  "control",
+		"MEDIA_SOURCE_KEY":     "media-secret-key",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.keys) != 1 || a.keys["sealed-secret-key"] != ScopeControl {
+		t.Errorf("keys = %v", a.keys)
+	}
+	if got := redact("key sealed-secret-key"); got != "key [redacted]" {
+		t.Errorf("the key is not redacted: %q", got)
+	}
+}
+
+func TestAPIRequiresKey(t *testing.T) {
+	withAuth(t, testAuth(t))
+	_, srv := newTestAPI(t)
+
+	if status := get(t, srv.URL+"/api/health", nil); status != http.StatusUnauthorized {
+		t.Errorf("without a key: status %d", status)
+	}
+	if status := get(t, srv.URL+"/api/health?key=phone-secret-key", nil); status != http.StatusOK {
+		t.Errorf("with a read key: status %d", status)
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL+"/api/health?key=phone-secret-key", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("from a foreign origin: status %d", resp.StatusCode)
+	}
+}
+
+func TestDebugVars(t *testing.T) {
+	withAuth(t, testAuth(t))
+	_, srv := newTestAPI(t)
+
+	if status := get(t, srv.URL+"/debug/vars", nil); status != http.StatusUnauthorized {
+		t.Errorf("without a key: status %d", status)
+	}
+
+	var vars map[string]any
+	if status := get(t, srv.URL+"/debug/vars?key=phone-secret-key", &vars); status != http.StatusOK {
+		t.Fatalf("with a read key: status %d", status)
+	}
+	if _, ok := vars["hoyolab"]; !ok {
+		t.Errorf("no hoyolab counters in %v", vars)
+	}
+}
+
+func TestEventsRequireKey(t *testing.T) {
+	withAuth(t, testAuth(t))
+
+	rec := httptest.NewRecorder()
+	serveEvents(rec, httptest.NewRequest("GET", "/events", nil), NewServer())
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status %d", rec.Code)
+	}
+}
+
+func TestCommandScopes(t *testing.T) {
+	withAlerts(t)
+
+	serv := NewServer()
+	client, _ := dialServer(t, serv)
+	s := &Session{serv: serv, client: client, scope: ScopeRead}
+
+	if resp := call(t, s, `{"id":"1","method":"subscribe","params":{"topics":["alerts"]}}`); resp.Error != nil {
+		t.Errorf("subscribe with read access: %v", resp.Error)
+	}
+	resp := call(t, s, `{"id":"2","method":"alert.ack","params":{"id":"cookie:os"}}`)
+	if resp.Error == nil || resp.Error.Code != ErrCodeForbidden {
+		t.Errorf("alert.ack with read access: %+v", resp.Error)
+	}
+}
+
+func dialWith(t *testing.T, handler http.HandlerFunc, query string, header http.Header) (*websocket.Conn, int) {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?"+query, header)
+	if err != nil {
+		if resp == nil {
+			t.Fatal(err)
+		}
+		return nil, resp.StatusCode
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, http.StatusSwitchingProtocols
+}
+
+func TestWebsocketAuth(t *testing.T) {
+	withAuth(t, testAuth(t))
+	serv := NewServer()
+	ws := func(w http.ResponseWriter, r *http.Request) { serveWs(w, r, serv, nil) }
+	media := func(w http.ResponseWriter, r *http.Request) { serveMediaSource(w, r, serv) }
+
+	foreign := http.Header{"Origin": {"https://evil.example.com"}}
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		query   string
+		header  http.Header
+		want    int
+	}{
+		{"ws without a key", ws, "", nil, http.StatusUnauthorized},
+		{"ws with a read key", ws, "key=phone-secret-key", nil, http.StatusSwitchingProtocols},
+		{"ws from a foreign page", ws, "key=phone-secret-key", foreign, http.StatusForbidden},
+		{"ws with the media key", ws, "key=media-secret-key", nil, http.StatusUnauthorized},
+		{"media without a key", media, "", nil, http.StatusUnauthorized},
+		{"media with an API key", media, "key=widget-secret-key", nil, http.StatusUnauthorized},
+		{"media with its key", media, "key=media-secret-key", nil, http.StatusSwitchingProtocols},
+		{"media from a foreign page", media, "key=media-secret-key", foreign, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if _, status := dialWith(t, tt.handler, tt.query, tt.header); status != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, status, tt.want)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	AlertVerificationRequired = "verification_required"
+	AlertVerificationResolved = "verification_resolved"
+)
+
+var (
+	verificationPageURL   = "https://act.hoyolab.com/app/community-game-records-sea/index.html"
+	cnVerificationPageURL = "https://webstatic.mihoyo.com/app/community-game-records/index.html"
+
+	verificationURL   = "https://bbs-api-os.hoyolab.com/game_record/app/card/wapi"
+	cnVerificationURL = "https://api-takumi-record.mihoyo.com/game_record/app/card/wapi"
+)
+
+type Verification struct {
+	Game      GameId `json:"game"`
+	Uid       string `json:"uid"`
+	URL       string `json:"url"`
+	Gt        string `json:"gt,omitempty"`
+	Challenge string `json:"challenge,omitempty"`
+	Since     int64  `json:"since"`
+}
+
+type VerificationError struct {
+	Verification Verification
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("%s %s is waiting for verification at %s", e.Verification.Game, e.Verification.Uid, e.Verification.URL)
+}
+
+// CaptchaGate pauses fetches for accounts HoYoLAB wants a geetest solved
+// for, so polling does not keep hammering an account that is already
+// flagged.
+type CaptchaGate struct {
+	mu        sync.Mutex
+	pending   map[string]Verification
+	retrying  map[string]bool
+	publish   func(topic string, data []byte)
+	listeners map[chan GameConfig]struct{}
+}
+
+var captchas = NewCaptchaGate()
+
+func NewCaptchaGate() *CaptchaGate {
+	return &CaptchaGate{
+		pending:   make(map[string]Verification),
+		retrying:  make(map[string]bool),
+		listeners: make(map[chan GameConfig]struct{}),
+	}
+}
+
+func accountKey(config GameConfig) string {
+	return string(config.game) + ":" + config.uid
+}
+
+func (g *CaptchaGate) SetPublisher(publish func(topic string, data []byte)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.publish = publish
+}
+
+// Register returns a channel that receives accounts as their verification
+// is resolved.
+func (g *CaptchaGate) Register() chan GameConfig {
+	ch := make(chan GameConfig, 1)
+	g.mu.Lock()
+	g.listeners[ch] = struct{}{}
+	g.mu.Unlock()
+	return ch
+}
+
+func (g *CaptchaGate) Unregister(ch chan GameConfig) {
+	g.mu.Lock()
+	delete(g.listeners, ch)
+	g.mu.Unlock()
+	close(ch)
+}
+
+func (g *CaptchaGate) Pending() []Verification {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	pending := make([]Verification, 0, len(g.pending))
+	for _, v := range g.pending {
+		pending = append(pending, v)
+	}
+	return pending
+}
+
+func (g *CaptchaGate) Paused(config GameConfig) (Verification, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	v, ok := g.pending[accountKey(config)]
+	return v, ok
+}
+
+func (g *CaptchaGate) Require(ctx context.Context, config GameConfig) Verification {
+	if v, ok := g.Paused(config); ok {
+		return v
+	}
+
+	v := Verification{
+		Game:  config.game,
+		Uid:   config.uid,
+		URL:   verificationPageURL,
+		Since: time.Now().Unix(),
+	}
+	if config.Region() == RegionChina {
+		v.URL = cnVerificationPageURL
+	}
+
+	gt, challenge, err := createVerification(ctx, config)
+	if err != nil {
+		log.Println("create verification:", err)
+	}
+	v.Gt, v.Challenge = gt, challenge
+
+	g.mu.Lock()
+	key := accountKey(config)
+	delete(g.retrying, key)
+	g.pending[key] = v
+	g.mu.Unlock()
+
+	log.Println("verification required for", config.game, "pausing fetches")
+	g.notify(AlertVerificationRequired, v)
+
+	return v
+}
+
+// Solved resumes an account. When the widget passes the geetest result it
+// is submitted to HoYoLAB first, otherwise the user solved it on the site and
+// the next fetch is let through to find out whether that worked.
+func (g *CaptchaGate) Solved(ctx context.Context, config GameConfig, challenge, validate, seccode string) error {
+	if validate == "" {
+		g.Retry(config)
+		return nil
+	}
+	if err := verifyVerification(ctx, config, challenge, validate, seccode); err != nil {
+		return err
+	}
+	g.resolve(config)
+	return nil
+}
+
+// Retry lets one fetch through for a paused account, the pause is lifted
+// for good if it succeeds.
+func (g *CaptchaGate) Retry(config GameConfig) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := accountKey(config)
+	if _, ok := g.pending[key]; ok {
+		delete(g.pending, key)
+		g.retrying[key] = true
+	}
+}
+
+func (g *CaptchaGate) Succeeded(config GameConfig) {
+	g.mu.Lock()
+	retrying := g.retrying[accountKey(config)]
+	g.mu.Unlock()
+
+	if retrying {
+		g.resolve(config)
+	}
+}
+
+func (g *CaptchaGate) resolve(config GameConfig) {
+	g.mu.Lock()
+	key := accountKey(config)
+	v, pending := g.pending[key]
+	retrying := g.retrying[key]
+	delete(g.pending, key)
+	delete(g.retrying, key)
+	if pending || retrying {
+		for l := range g.listeners {
+			select {
+			case l <- config:
+			default:
+			}
+		}
+	}
+	g.mu.Unlock()
+
+	if !pending && !retrying {
+		return
+	}
+	if !pending {
+		v = Verification{Game: config.game, Uid: config.uid}
+	}
+
+	log.Println("verification resolved for", config.game, "resuming fetches")
+	g.notify(AlertVerificationResolved, v)
+}
+
+func (g *CaptchaGate) notify(kind string, v Verification) {
+	g.mu.Lock()
+	publish := g.publish
+	g.mu.Unlock()
+
+	alert := verificationAlert(kind, v)
+	if kind == AlertVerificationRequired {
+		alerts.Raised(alert.Id)
+	}
+
+	if publish == nil {
+		return
+	}
+	data, err := encodeEvent(TopicAlerts, alert)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	publish(TopicAlerts, data)
+}
+
+type VerificationAlert struct {
+	Kind string `json:"kind"`
+	Id   string `json:"id"`
+	Verification
+}
+
+func verificationAlert(kind string, v Verification) VerificationAlert {
+	return VerificationAlert{Kind: kind, Id: "verification:" + string(v.Game) + ":" + v.Uid, Verification: v}
+}
+
+func verificationRequest(ctx context.Context, config GameConfig, method, path string, body []byte) (*http.Request, error) {
+	base := verificationURL
+	if config.Region() == RegionChina {
+		base = cnVerificationURL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, base+"/"+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Region() == RegionChina {
+		req.Header.Set("DS", generateDS2(dsSaltCNBody, string(body), req.URL.Query()))
+	} else {
+		req.Header.Set("DS", generateDS())
+	}
+	req.Header.Set("Cookie", config.Cookie())
+	req.Header.Set("x-rpc-client_type", "5")
+	req.Header.Set("x-rpc-app_version", config.version)
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+func doVerificationRequest(req *http.Request, v any) error {
+	_, b, err := hoyo.Do(req)
+	if err != nil {
+		return err
+	}
+
+	var result recordResponse
+	if err := json.Unmarshal(b, &result); err != nil {
+		return err
+	}
+	if result.Retcode != 0 {
+		return fmt.Errorf("verification failed: %d %s", result.Retcode, result.Message)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(result.Data, v)
+}
+
+func createVerification(ctx context.Context, config GameConfig) (gt, challenge string, err error) {
+	req, err := verificationRequest(ctx, config, "GET", "createVerification?is_high=true", nil)
+	if err != nil {
+		return "", "", err
+	}
+
+	var data struct {
+		Gt        string `json:"gt"`
+		Challenge string `json:"challenge"`
+	}
+	if err := doVerificationRequest(req, &data); err != nil {
+		return "", "", err
+	}
+	return data.Gt, data.Challenge, nil
+}
+
+func verifyVerification(ctx context.Context, config GameConfig, challenge, validate, seccode string) error {
+	if challenge == "" {
+		return errors.New("missing geetest challenge")
+	}
+	if seccode == "" {
+		seccode = validate + "|jordan"
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"geetest_challenge": challenge,
+		"geetest_validate":  validate,
+		"geetest_seccode":   seccode,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := verificationRequest(ctx, config, "POST", "verifyVerification", body)
+	if err != nil {
+		return err
+	}
+	return doVerificationRequest(req, nil)
+}
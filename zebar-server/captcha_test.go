@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type fakeCaptcha struct {
+	mu       sync.Mutex
+	solved   bool
+	notes    int
+	verified map[string]string
+}
+
+func (f *fakeCaptcha) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/createVerification"):
+		io.WriteString(w, `{"retcode":0,"message":"OK","data":{"gt":"gt-123","challenge":"challenge-456","new_captcha":1,"success":1}}`)
+	case strings.HasSuffix(r.URL.Path, "/verifyVerification"):
+		b, _ := io.ReadAll(r.Body)
+		json.Unmarshal(b, &f.verified)
+		f.solved = true
+		io.WriteString(w, `{"retcode":0,"message":"OK","data":{"challenge":"challenge-456"}}`)
+	default:
+		f.notes++
+		if !f.solved {
+			io.WriteString(w, `{"retcode":1034,"message":"","data":null}`)
+			return
+		}
+		b, _ := os.ReadFile("testdata/genshin_note.json")
+		w.Write(b)
+	}
+}
+
+func (f *fakeCaptcha) noteCalls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.notes
+}
+
+func withFakeCaptcha(t *testing.T, fake *fakeCaptcha) *[]map[string]any {
+	t.Helper()
+	withTestAccount(t)
+
+	withFakeFp(t, &fakeFp{fp: "fresh-fp"})
+	withDevice(t, &Device{Id: "device", Fps: map[Region]string{RegionOverseas: "fp"}})
+
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	withCaptchaGate(t)
+
+	oldRecord, oldVerification := recordURL, verificationURL
+	recordURL, verificationURL = srv.URL, srv.URL+"/card/wapi"
+	t.Cleanup(func() { recordURL, verificationURL = oldRecord, oldVerification })
+
+	var (
+		mu       sync.Mutex
+		messages []map[string]any
+	)
+	captchas.SetPublisher(func(topic string, data []byte) {
+		msg := decodeEvent(t, data, TopicAlerts)
+		mu.Lock()
+		messages = append(messages, msg)
+		mu.Unlock()
+	})
+	return &messages
+}
+
+func TestCaptchaPausesAndResumesWithSolution(t *testing.T) {
+	fake := &fakeCaptcha{}
+	messages := withFakeCaptcha(t, fake)
+
+	resolved := captchas.Register()
+	defer captchas.Unregister(resolved)
+
+	if _, err := DailyNote(t.Context(), GenshinConfig); err == nil {
+		t.Fatal("expected captcha error")
+	}
+	calls := fake.noteCalls()
+
+	v, paused := captchas.Paused(GenshinConfig)
+	if !paused || v.Gt != "gt-123" || v.Challenge != "challenge-456" || v.URL == "" {
+		t.Fatalf("verification = %+v, paused = %v", v, paused)
+	}
+	if len(*messages) != 1 || (*messages)[0]["kind"] != AlertVerificationRequired || (*messages)[0]["challenge"] != "challenge-456" {
+		t.Errorf("messages = %v", *messages)
+	}
+
+	_, err := DailyNote(t.Context(), GenshinConfig)
+	if _, ok := err.(*VerificationError); !ok {
+		t.Errorf("err while paused = %v", err)
+	}
+	if fake.noteCalls() != calls {
+		t.Error("paused account still fetched notes")
+	}
+
+	if err := captchas.Solved(t.Context(), GenshinConfig, "challenge-456", "validate-789", ""); err != nil {
+		t.Fatal(err)
+	}
+	if fake.verified["geetest_validate"] != "validate-789" || fake.verified["geetest_seccode"] != "validate-789|jordan" {
+		t.Errorf("verified with %v", fake.verified)
+	}
+	if config := <-resolved; config.game != GENSHIN {
+		t.Errorf("resolved %s", config.game)
+	}
+	if len(*messages) != 2 || (*messages)[1]["kind"] != AlertVerificationResolved {
+		t.Errorf("messages = %v", *messages)
+	}
+
+	note, err := DailyNote(t.Context(), GenshinConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note.Current != 112 {
+		t.Errorf("note = %+v", note)
+	}
+}
+
+func TestCaptchaResumesAfterManualRefresh(t *testing.T) {
+	fake := &fakeCaptcha{}
+	messages := withFakeCaptcha(t, fake)
+
+	DailyNote(t.Context(), GenshinConfig)
+	if _, paused := captchas.Paused(GenshinConfig); !paused {
+		t.Fatal("account not paused")
+	}
+
+	captchas.Retry(GenshinConfig)
+	if _, err := DailyNote(t.Context(), GenshinConfig); err == nil {
+		t.Fatal("expected captcha error while still unsolved")
+	}
+	if _, paused := captchas.Paused(GenshinConfig); !paused {
+		t.Fatal("failed retry did not pause the account again")
+	}
+
+	fake.mu.Lock()
+	fake.solved = true
+	fake.mu.Unlock()
+
+	captchas.Retry(GenshinConfig)
+	if _, err := DailyNote(t.Context(), GenshinConfig); err != nil {
+		t.Fatal(err)
+	}
+	if _, paused := captchas.Paused(GenshinConfig); paused {
+		t.Error("account still paused after a successful refresh")
+	}
+
+	last := (*messages)[len(*messages)-1]
+	if last["kind"] != AlertVerificationResolved {
+		t.Errorf("last message = %v", last)
+	}
+}
+
+func TestCaptchaPausesPerAccount(t *testing.T) {
+	withFakeCaptcha(t, &fakeCaptcha{})
+
+	DailyNote(t.Context(), GenshinConfig)
+	if _, paused := captchas.Paused(StarRailConfig); paused {
+		t.Error("captcha on genshin paused star rail")
+	}
+}
+
+func TestCaptchaSolvedOnSite(t *testing.T) {
+	fake := &fakeCaptcha{}
+	messages := withFakeCaptcha(t, fake)
+
+	DailyNote(t.Context(), GenshinConfig)
+	if _, paused := captchas.Paused(GenshinConfig); !paused {
+		t.Fatal("account not paused")
+	}
+
+	serv := NewServer()
+	s := &Session{serv: serv, updater: NewResinUpdater(t.Context(), serv), scope: ScopeControl}
+	solve := `{"id":"s","method":"captcha.solve","params":{"game":"genshin"}}`
+
+	if resp := call(t, s, solve); resp.Error == nil {
+		t.Fatal("expected an error while the captcha is still unsolved")
+	}
+	if _, paused := captchas.Paused(GenshinConfig); !paused {
+		t.Fatal("account resumed without a successful fetch")
+	}
+
+	fake.mu.Lock()
+	fake.solved = true
+	fake.mu.Unlock()
+
+	if resp := call(t, s, solve); resp.Error != nil {
+		t.Fatalf("error = %+v", resp.Error)
+	}
+	if _, paused := captchas.Paused(GenshinConfig); paused {
+		t.Error("account still paused")
+	}
+	if last := (*messages)[len(*messages)-1]; last["kind"] != AlertVerificationResolved {
+		t.Errorf("last message = %v", last)
+	}
+}
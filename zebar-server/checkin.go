@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	CheckInSigned  = "signed"
+	CheckInAlready = "already_signed"
+	CheckInCaptcha = "captcha"
+	CheckInFailed  = "failed"
+
+	retcodeAlreadySignedIn = -5003
+	retcodeCaptcha         = 1034
+)
+
+// check-in days roll over at midnight UTC+8 for every region, not at the
+// 04:00 server reset
+var checkInLocation = time.FixedZone("UTC+8", 8*60*60)
+
+type checkInEndpoint struct {
+	url      string
+	actId    string
+	signGame string
+}
+
+type CheckInReward struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	Icon  string `json:"icon"`
+}
+
+type CheckInResult struct {
+	Game      GameId         `json:"game"`
+	Status    string         `json:"status"`
+	Reward    *CheckInReward `json:"reward,omitempty"`
+	TotalDays int            `json:"totalDays"`
+	Message   string         `json:"message,omitempty"`
+	Time      int64          `json:"time"`
+}
+
+type checkInRisk struct {
+	RiskCode  int    `json:"risk_code"`
+	Gt        string `json:"gt"`
+	Challenge string `json:"challenge"`
+	IsRisk    bool   `json:"is_risk"`
+}
+
+func (r *checkInRisk) captcha() bool {
+	return r != nil && (r.IsRisk || r.RiskCode != 0)
+}
+
+type checkInSignResponse struct {
+	Retcode int    `json:"retcode"`
+	Message string `json:"message"`
+	Data    *struct {
+		checkInRisk
+		GtResult *checkInRisk `json:"gt_result"`
+	} `json:"data"`
+}
+
+type checkInInfoResponse struct {
+	Retcode int    `json:"retcode"`
+	Message string `json:"message"`
+	Data    struct {
+		TotalSignDay int    `json:"total_sign_day"`
+		Today        string `json:"today"`
+		IsSign       bool   `json:"is_sign"`
+	} `json:"data"`
+}
+
+type checkInHomeResponse struct {
+	Retcode int    `json:"retcode"`
+	Message string `json:"message"`
+	Data    struct {
+		Awards []struct {
+			Name string `json:"name"`
+			Cnt  int    `json:"cnt"`
+			Icon string `json:"icon"`
+		} `json:"awards"`
+	} `json:"data"`
+}
+
+func checkInRequest(ctx context.Context, config GameConfig, endpoint checkInEndpoint, method, path string, body io.Reader) (*http.Request, error) {
+	url := fmt.Sprintf("%s/%s?act_id=%s&lang=en-us", endpoint.url, path, endpoint.actId)
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Cookie", config.Cookie())
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Referer", "https://act.hoyolab.com/")
+	req.Header.Set("x-rpc-app_version", config.version)
+	req.Header.Set("x-rpc-client_type", "5")
+	if endpoint.signGame != "" {
+		req.Header.Set("x-rpc-signgame", endpoint.signGame)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+func doCheckInRequest(req *http.Request, v any) error {
+	_, bytes, err := hoyo.Do(req)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bytes, v)
+}
+
+func CheckIn(ctx context.Context, config GameConfig) (CheckInResult, error) {
+	if config.Region() == RegionChina {
+		return CheckInResult{}, fmt.Errorf("check-in is not supported for CN accounts")
+	}
+
+	game, ok := GameById(config.game)
+	if !ok {
+		return CheckInResult{}, fmt.Errorf("no check-in endpoint for %s", config.game)
+	}
+	endpoint := game.CheckInEndpoint()
+
+	result := CheckInResult{
+		Game: config.game,
+		Time: time.Now().Unix(),
+	}
+
+	body, err := json.Marshal(map[string]string{"act_id": endpoint.actId})
+	if err != nil {
+		return CheckInResult{}, err
+	}
+	req, err := checkInRequest(ctx, config, endpoint, "POST", "sign", bytes.NewReader(body))
+	if err != nil {
+		return CheckInResult{}, err
+	}
+
+	var sign checkInSignResponse
+	if err := doCheckInRequest(req, &sign); err != nil {
+		return CheckInResult{}, err
+	}
+
+	switch {
+	case sign.Retcode == retcodeAlreadySignedIn:
+		result.Status = CheckInAlready
+	case sign.Retcode == retcodeCaptcha:
+		result.Status = CheckInCaptcha
+	case sign.Retcode != 0:
+		return CheckInResult{}, fmt.Errorf("check-in for %s failed: %d %s", config.game, sign.Retcode, sign.Message)
+	case sign.Data != nil && (sign.Data.checkInRisk.captcha() || sign.Data.GtResult.captcha()):
+		result.Status = CheckInCaptcha
+	default:
+		result.Status = CheckInSigned
+	}
+	result.Message = sign.Message
+
+	if result.Status == CheckInCaptcha {
+		return result, nil
+	}
+
+	reward, days, err := checkInReward(ctx, config, endpoint)
+	if err != nil {
+		return CheckInResult{}, err
+	}
+	result.Reward = reward
+	result.TotalDays = days
+
+	return result, nil
+}
+
+func checkInReward(ctx context.Context, config GameConfig, endpoint checkInEndpoint) (*CheckInReward, int, error) {
+	req, err := checkInRequest(ctx, config, endpoint, "GET", "info", nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	var info checkInInfoResponse
+	if err := doCheckInRequest(req, &info); err != nil {
+		return nil, 0, err
+	}
+	if info.Retcode != 0 {
+		return nil, 0, fmt.Errorf("check-in info for %s failed: %d %s", config.game, info.Retcode, info.Message)
+	}
+
+	req, err = checkInRequest(ctx, config, endpoint, "GET", "home", nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	var home checkInHomeResponse
+	if err := doCheckInRequest(req, &home); err != nil {
+		return nil, 0, err
+	}
+	if home.Retcode != 0 {
+		return nil, 0, fmt.Errorf("check-in rewards for %s failed: %d %s", config.game, home.Retcode, home.Message)
+	}
+
+	days := info.Data.TotalSignDay
+	if days < 1 || days > len(home.Data.Awards) {
+		return nil, days, errors.New("check-in reward out of range")
+	}
+	award := home.Data.Awards[days-1]
+
+	return &CheckInReward{Name: award.Name, Count: award.Cnt, Icon: award.Icon}, days, nil
+}
+
+func NextCheckInReset(now time.Time) time.Time {
+	t := now.In(checkInLocation)
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, checkInLocation)
+}
+
+type CheckInScheduler struct {
+	serv *Server
+
+	mu      sync.Mutex
+	results map[GameId]CheckInResult
+}
+
+func NewCheckInScheduler(serv *Server) *CheckInScheduler {
+	return &CheckInScheduler{
+		serv:    serv,
+		results: make(map[GameId]CheckInResult),
+	}
+}
+
+func (c *CheckInScheduler) Results() []CheckInResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	results := make([]CheckInResult, 0, len(c.results))
+	for _, config := range Configs() {
+		if result, ok := c.results[config.game]; ok {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// Snapshot replays today's check-in results to new subscribers.
+func (c *CheckInScheduler) Snapshot(string) [][]byte {
+	var messages [][]byte
+	for _, result := range c.Results() {
+		data, err := encodeEvent(TopicCheckIn, result)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		messages = append(messages, data)
+	}
+	return messages
+}
+
+// Run checks every account in once a day until ctx is cancelled.
+func (c *CheckInScheduler) Run(ctx context.Context, configs []GameConfig) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for _, config := range configs {
+		if config.Region() == RegionChina {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.run(ctx, config)
+		}()
+	}
+}
+
+func (c *CheckInScheduler) run(ctx context.Context, config GameConfig) {
+	for {
+		c.CheckIn(ctx, config)
+
+		next := NextCheckInReset(time.Now()).Add(resetGrace)
+		timer := time.NewTimer(time.Until(next))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *CheckInScheduler) CheckIn(ctx context.Context, config GameConfig) CheckInResult {
+	result, err := CheckIn(ctx, config)
+	if err != nil {
+		log.Println(err)
+		result = CheckInResult{
+			Game:    config.game,
+			Status:  CheckInFailed,
+			Message: redactError(err),
+			Time:    time.Now().Unix(),
+		}
+	}
+	log.Println("check-in for", config.game, result.Status)
+
+	c.mu.Lock()
+	c.results[config.game] = result
+	c.mu.Unlock()
+
+	data, err := encodeEvent(TopicCheckIn, result)
+	if err != nil {
+		log.Println(err)
+		return result
+	}
+	c.serv.Publish(TopicCheckIn, data)
+
+	return result
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeCheckIn struct {
+	signResponse string
+	signs        int
+	cookie       string
+	signGame     string
+	actId        string
+}
+
+func (f *fakeCheckIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.cookie = r.Header.Get("Cookie")
+	f.signGame = r.Header.Get("x-rpc-signgame")
+
+	switch r.URL.Path {
+	case "/sign":
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body struct {
+			ActId string `json:"act_id"`
+		}
+		b, _ := io.ReadAll(r.Body)
+		json.Unmarshal(b, &body)
+		f.actId = body.ActId
+		f.signs++
+		io.WriteString(w, f.signResponse)
+	case "/info":
+		io.WriteString(w, `{"retcode":0,"message":"OK","data":{"total_sign_day":3,"today":"2025-06-16","is_sign":true}}`)
+	case "/home":
+		io.WriteString(w, `{"retcode":0,"message":"OK","data":{"awards":[
+			{"name":"Primogem","cnt":20,"icon":"a.png"},
+			{"name":"Mora","cnt":5000,"icon":"b.png"},
+			{"name":"Fine Enhancement Ore","cnt":3,"icon":"c.png"},
+			{"name":"Hero's Wit","cnt":2,"icon":"d.png"}
+		]}}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func withFakeCheckIn(t *testing.T, fake *fakeCheckIn) {
+	t.Helper()
+	withTestAccount(t)
+
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	var games []Game
+	for _, game := range Games() {
+		games = append(games, fakeCheckInGame{Game: game, url: srv.URL})
+	}
+	withGames(t, games...)
+}
+
+// fakeCheckInGame sends game's check-ins to url.
+type fakeCheckInGame struct {
+	Game
+	url string
+}
+
+func (g fakeCheckInGame) CheckInEndpoint() checkInEndpoint {
+	endpoint := g.Game.CheckInEndpoint()
+	endpoint.url = g.url
+	return endpoint
+}
+
+func TestCheckIn(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		status   string
+		reward   bool
+	}{
+		{"signed", `{"retcode":0,"message":"OK","data":{"code":"","risk_code":0,"gt":"","challenge":"","success":0,"is_risk":false}}`, CheckInSigned, true},
+		{"already signed", `{"retcode":-5003,"message":"Traveler, you've already checked in today~","data":null}`, CheckInAlready, true},
+		{"captcha retcode", `{"retcode":1034,"message":"","data":null}`, CheckInCaptcha, false},
+		{"captcha risk", `{"retcode":0,"message":"OK","data":{"code":"","risk_code":375,"gt":"abc","challenge":"def","success":1,"is_risk":true}}`, CheckInCaptcha, false},
+		{"captcha gt result", `{"retcode":0,"message":"OK","data":{"code":"ok","gt_result":{"risk_code":375,"gt":"abc","challenge":"def","success":1,"is_risk":true}}}`, CheckInCaptcha, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeCheckIn{signResponse: tt.response}
+			withFakeCheckIn(t, fake)
+
+			result, err := CheckIn(t.Context(), ZZZConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Status != tt.status {
+				t.Errorf("status = %s, want %s", result.Status, tt.status)
+			}
+			if tt.reward && (result.Reward == nil || result.Reward.Name != "Fine Enhancement Ore" || result.TotalDays != 3) {
+				t.Errorf("reward = %+v, days = %d", result.Reward, result.TotalDays)
+			}
+			if !tt.reward && result.Reward != nil {
+				t.Errorf("unexpected reward %+v", result.Reward)
+			}
+			if fake.cookie != "ltoken_v2=test" || fake.signGame != "zzz" || fake.actId != (zzzGame{}).CheckInEndpoint().actId {
+				t.Errorf("sign request cookie = %q, signgame = %q, act_id = %q", fake.cookie, fake.signGame, fake.actId)
+			}
+		})
+	}
+}
+
+func TestCheckInError(t *testing.T) {
+	withFakeCheckIn(t, &fakeCheckIn{signResponse: `{"retcode":-100,"message":"Not logged in","data":null}`})
+
+	if _, err := CheckIn(t.Context(), GenshinConfig); err == nil {
+		t.Error("expected an error for a rejected cookie")
+	}
+}
+
+func TestCheckInSchedulerRecordsResult(t *testing.T) {
+	fake := &fakeCheckIn{signResponse: `{"retcode":0,"message":"OK","data":{"risk_code":0,"is_risk":false}}`}
+	withFakeCheckIn(t, fake)
+
+	c := NewCheckInScheduler(NewServer())
+	c.CheckIn(t.Context(), StarRailConfig)
+
+	results := c.Results()
+	if len(results) != 1 || results[0].Game != STARRAIL || results[0].Status != CheckInSigned {
+		t.Errorf("results = %+v", results)
+	}
+	if fake.signs != 1 {
+		t.Errorf("signs = %d, want 1", fake.signs)
+	}
+}
+
+func TestNextCheckInReset(t *testing.T) {
+	now, _ := time.Parse(time.RFC3339, "2025-06-16T15:59:00Z")
+	want, _ := time.Parse(time.RFC3339, "2025-06-16T16:00:00Z")
+	if got := NextCheckInReset(now); !got.Equal(want) {
+		t.Errorf("NextCheckInReset = %s, want %s", got.UTC(), want)
+	}
+
+	now, _ = time.Parse(time.RFC3339, "2025-06-16T16:00:00Z")
+	want, _ = time.Parse(time.RFC3339, "2025-06-17T16:00:00Z")
+	if got := NextCheckInReset(now); !got.Equal(want) {
+		t.Errorf("NextCheckInReset = %s, want %s", got.UTC(), want)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import "time"
+
+const (
+	ResetDaily  = "daily"
+	ResetWeekly = "weekly"
+)
+
+type TodoItem struct {
+	Id       string `json:"id"`
+	Game     GameId `json:"game"`
+	Name     string `json:"name"`
+	Done     int    `json:"done"`
+	Total    int    `json:"total"`
+	Complete bool   `json:"complete"`
+	Reset    string `json:"reset"`
+	ResetsAt int64  `json:"resetsAt,omitempty"`
+}
+
+type Checklist struct {
+	Items  []TodoItem        `json:"items"`
+	Errors map[GameId]string `json:"errors,omitempty"`
+}
+
+func newTodo(game GameId, id, name string, done, total int, reset string) TodoItem {
+	return TodoItem{
+		Id:       string(game) + "." + id,
+		Game:     game,
+		Name:     name,
+		Done:     done,
+		Total:    total,
+		Complete: total > 0 && done >= total,
+		Reset:    reset,
+	}
+}
+
+func boolCount(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+type ChecklistPayload struct {
+	Game        GameId     `json:"game"`
+	Items       []TodoItem `json:"items"`
+	DailyReset  int64      `json:"dailyReset"`
+	WeeklyReset int64      `json:"weeklyReset"`
+}
+
+func checklistPayload(note DailyNoteCommon) ChecklistPayload {
+	now := time.Now()
+
+	return ChecklistPayload{
+		Game:        note.Game,
+		Items:       note.Todos,
+		DailyReset:  NextDailyReset(note.Server, now).Unix(),
+		WeeklyReset: NextWeeklyReset(note.Server, now).Unix(),
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func decodeFixture(t *testing.T, id GameId, name string) Note {
+	t.Helper()
+
+	game, ok := GameById(id)
+	if !ok {
+		t.Fatalf("game %s is not registered", id)
+	}
+	b, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	note, err := game.DecodeNote(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return note
+}
+
+func findTodo(t *testing.T, items []TodoItem, id string) TodoItem {
+	t.Helper()
+
+	for _, item := range items {
+		if item.Id == id {
+			return item
+		}
+	}
+	t.Fatalf("todo %s not found in %v", id, items)
+	return TodoItem{}
+}
+
+func TestGenshinTodos(t *testing.T) {
+	items := decodeFixture(t, GENSHIN, "genshin_note.json").Todos(time.Now())
+
+	commissions := findTodo(t, items, "genshin.commissions")
+	if commissions.Done != 3 || commissions.Total != 4 || commissions.Complete {
+		t.Errorf("commissions = %+v", commissions)
+	}
+	bosses := findTodo(t, items, "genshin.weekly_bosses")
+	if bosses.Done != 2 || bosses.Total != 3 || bosses.Reset != ResetWeekly {
+		t.Errorf("weekly bosses = %+v", bosses)
+	}
+}
+
+func TestStarRailTodos(t *testing.T) {
+	items := decodeFixture(t, STARRAIL, "hkrpg_note.json").Todos(time.Now())
+
+	training := findTodo(t, items, "hkrpg.daily_training")
+	if !training.Complete || training.Reset != ResetDaily {
+		t.Errorf("daily training = %+v", training)
+	}
+	echoes := findTodo(t, items, "hkrpg.echo_of_war")
+	if echoes.Done != 0 || echoes.Total != 3 || echoes.Complete {
+		t.Errorf("echo of war = %+v", echoes)
+	}
+	su := findTodo(t, items, "hkrpg.simulated_universe")
+	if su.Done != 4200 || su.Total != 14000 {
+		t.Errorf("simulated universe = %+v", su)
+	}
+}
+
+func TestZZZTodos(t *testing.T) {
+	now := time.Unix(1750000000, 0)
+	items := decodeFixture(t, ZZZ, "zzz_note.json").Todos(now)
+
+	engagement := findTodo(t, items, "zzz.engagement")
+	if !engagement.Complete {
+		t.Errorf("engagement = %+v", engagement)
+	}
+	ridu := findTodo(t, items, "zzz.ridu_weekly")
+	if ridu.Done != 700 || ridu.Total != 1300 || ridu.ResetsAt != now.Unix()+302400 {
+		t.Errorf("ridu weekly = %+v", ridu)
+	}
+}
+
+func TestHonkai3Todos(t *testing.T) {
+	items := decodeFixture(t, HONKAI3, "bh3_note.json").Todos(time.Now())
+
+	training := findTodo(t, items, "bh3.daily_training")
+	if training.Done != 400 || training.Total != 600 || training.Reset != ResetDaily {
+		t.Errorf("daily training = %+v", training)
+	}
+	manifold := findTodo(t, items, "bh3.q_manifold")
+	if !manifold.Complete || manifold.ResetsAt != 1750636800 {
+		t.Errorf("q-manifold = %+v", manifold)
+	}
+	arena := findTodo(t, items, "bh3.memorial_arena")
+	if arena.Complete || arena.Total != 560 {
+		t.Errorf("memorial arena = %+v", arena)
+	}
+}
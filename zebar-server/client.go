@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+var requestTimeout = flag.Duration("request-timeout", 15*time.Second, "timeout for a single HoYoLAB request")
+
+// HoYoLAB responses are a few KB, anything near this is not a real one.
+const maxResponseBytes = 1 << 20
+
+var errResponseTooLarge = errors.New("response body too large")
+
+// hoyoMetrics is published on /debug/vars.
+var hoyoMetrics = expvar.NewMap("hoyolab")
+
+// hoyoClient sends the requests made to HoYoLAB and miHoYo. Every request is
+// bounded by a timeout on top of the caller's context, and only the first
+// maxBody bytes of a response are read. A nil limiter sends requests as fast
+// as they come.
+type hoyoClient struct {
+	client  *http.Client
+	limiter *tokenBucket
+	timeout time.Duration
+	maxBody int64
+}
+
+func newHoyoClient(timeout time.Duration) *hoyoClient {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+
+	return &hoyoClient{
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				DialContext:           dialer.DialContext,
+				TLSHandshakeTimeout:   10 * time.Second,
+				ResponseHeaderTimeout: timeout,
+				IdleConnTimeout:       90 * time.Second,
+				MaxIdleConns:          10,
+			},
+		},
+		timeout: timeout,
+		maxBody: maxResponseBytes,
+	}
+}
+
+var hoyo = newHoyoClient(*requestTimeout)
+
+// Do sends req and returns the response along with its body, which has
+// already been read and closed.
+func (c *hoyoClient) Do(req *http.Request) (*http.Response, []byte, error) {
+	if c.limiter != nil {
+		waited, err := c.limiter.Wait(req.Context())
+		if err != nil {
+			return nil, nil, err
+		}
+		if waited > 0 {
+			hoyoMetrics.Add("throttled", 1)
+			hoyoMetrics.Add("throttled_ms", waited.Milliseconds())
+		}
+	}
+	hoyoMetrics.Add("requests", 1)
+
+	ctx, cancel := context.WithTimeout(req.Context(), c.timeout)
+	defer cancel()
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := readLimited(resp.Body, c.maxBody)
+	if err != nil {
+		return resp, nil, fmt.Errorf("%s %s: %w", req.Method, req.URL.Path, err)
+	}
+	return resp, b, nil
+}
+
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, errResponseTooLarge
+	}
+	return b, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func withHoyoClient(t *testing.T, c *hoyoClient) {
+	t.Helper()
+
+	old := hoyo
+	hoyo = c
+	t.Cleanup(func() { hoyo = old })
+}
+
+// withSlowRecords serves note requests that never finish until the test
+// ends, and reports when one arrives.
+func withSlowRecords(t *testing.T) <-chan struct{} {
+	t.Helper()
+	withTestAccount(t)
+
+	arrived := make(chan struct{}, 10)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+
+	oldRecord, oldZZZ := recordURL, zzzRecordURL
+	recordURL, zzzRecordURL = srv.URL, srv.URL+"/zzz"
+	t.Cleanup(func() { recordURL, zzzRecordURL = oldRecord, oldZZZ })
+
+	return arrived
+}
+
+func TestRequestTimeout(t *testing.T) {
+	withSlowRecords(t)
+	withHoyoClient(t, newHoyoClient(50*time.Millisecond))
+	withCaptchaGate(t)
+
+	start := time.Now()
+	_, err := DailyNote(t.Context(), GenshinConfig)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("timed out after %v", elapsed)
+	}
+}
+
+func TestRequestCancelled(t *testing.T) {
+	arrived := withSlowRecords(t)
+	withHoyoClient(t, newHoyoClient(time.Minute))
+	withCaptchaGate(t)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := DailyNote(ctx, GenshinConfig)
+		errs <- err
+	}()
+
+	<-arrived
+	cancel()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("fetch was not cancelled")
+	}
+}
+
+func TestUpdatesStopWithContext(t *testing.T) {
+	arrived := withSlowRecords(t)
+	withHoyoClient(t, newHoyoClient(time.Minute))
+	withCaptchaGate(t)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	u := NewResinUpdater(ctx, NewServer())
+
+	done := make(chan error, 1)
+	go func() { done <- u.RunDailyNoteUpdates(ctx, GenshinConfig) }()
+
+	<-arrived
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("updater kept running after its context was cancelled")
+	}
+}
+
+func TestResponseLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"retcode":0,"message":"` + strings.Repeat("x", 2048) + `"}`))
+	}))
+	defer srv.Close()
+
+	c := newHoyoClient(time.Second)
+	c.maxBody = 1024
+
+	req, _ := http.NewRequestWithContext(t.Context(), "GET", srv.URL+"/note", nil)
+	if _, _, err := c.Do(req); !errors.Is(err, errResponseTooLarge) {
+		t.Errorf("err = %v, want %v", err, errResponseTooLarge)
+	}
+
+	c.maxBody = 4096
+	req, _ = http.NewRequestWithContext(t.Context(), "GET", srv.URL+"/note", nil)
+	if _, b, err := c.Do(req); err != nil || len(b) < 2048 {
+		t.Errorf("read %d bytes, err = %v", len(b), err)
+	}
+}
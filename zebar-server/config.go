@@ -3,100 +3,155 @@ package main
 import (
 	"bufio"
 	"flag"
-	"log"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 )
 
 type GameId = string
 
+type Region = string
+
 var (
-	addr = flag.String("addr", "localhost:45456", "http service address")
+	addr     = flag.String("addr", "localhost:45456", "http service address")
+	stateDir = flag.String("state", defaultStateDir(), "directory for persisted state")
+
+	shutdownTimeout = flag.Duration("shutdown-timeout", 5*time.Second, "how long requests in flight get to finish on shutdown")
 )
 
+func defaultStateDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "zbserv"
+	}
+	return filepath.Join(dir, "zbserv")
+}
+
 const (
 	GENSHIN  GameId = "genshin"
 	STARRAIL GameId = "hkrpg"
 	ZZZ      GameId = "zzz"
+	HONKAI3  GameId = "bh3"
+	TEARS    GameId = "nxx"
 
-	uidGenshin = "604392290"
-	uidHSR     = "614963011"
-
-	serverGenshin = "os_usa"
-	serverHsr     = "prod_official_usa"
-
-	versionGenshin = "2.11.1"
-	versionHsr     = "2.50.1"
+	RegionOverseas Region = "os"
+	RegionChina    Region = "cn"
 
 	dsSalt = "6s25p5ox5y14umn1p61aqyyvbvvl3lrt"
 )
 
 type GameConfig struct {
-	game          GameId
-	path          string
-	uid           string
-	gamePath      string
-	server        string
-	cookie        string
-	version       string
-	resinRecharge time.Duration
+	game    GameId
+	uid     string
+	server  string
+	region  Region
+	version string
 }
 
-var ZZZConfig = GameConfig{
-	game:          ZZZ,
-	gamePath:      "zzz",
-	path:          "note",
-	uid:           "1000482805",
-	server:        "prod_gf_us",
-	resinRecharge: time.Minute * 6,
+var chinaServers = map[string]bool{
+	"cn_gf01":    true,
+	"cn_qd01":    true,
+	"prod_gf_cn": true,
+	"prod_qd_cn": true,
 }
 
-var StarRailConfig = GameConfig{
-	game:          STARRAIL,
-	gamePath:      "hkrpg",
-	path:          "note",
-	uid:           uidHSR,
-	server:        serverHsr,
-	version:       versionHsr,
-	resinRecharge: time.Second * 360,
+// Cookie is the current cookie for the account's region.
+func (c GameConfig) Cookie() string {
+	if creds, ok := credentials[c.Region()]; ok {
+		return creds.Cookie()
+	}
+	return ""
+}
+
+// Region is the explicitly configured region, falling back to the one the
+// server belongs to.
+func (c GameConfig) Region() Region {
+	if c.region != "" {
+		return c.region
+	}
+	if chinaServers[c.server] {
+		return RegionChina
+	}
+	return RegionOverseas
 }
 
-var GenshinConfig = GameConfig{
-	game:          GENSHIN,
-	gamePath:      "genshin",
-	path:          "dailyNote",
-	uid:           uidGenshin,
-	server:        serverGenshin,
-	version:       versionGenshin,
-	resinRecharge: time.Second * 480,
+// Configs returns the configured accounts in registry order, accounts for
+// games that are not registered or have no uid are skipped.
+func Configs() []GameConfig {
+	var configs []GameConfig
+	for _, game := range Games() {
+		if config := *game.Account(); config.uid != "" {
+			configs = append(configs, config)
+		}
+	}
+	return configs
 }
 
-func init() {
-	file, err := os.Open("C:\\Users\\david\\dev\\go\\zebar-config\\zebar-server\\conf.env")
+var configPath = flag.String("config", "C:\\Users\\david\\dev\\go\\zebar-config\\zebar-server\\conf.env", "path to conf.env")
+
+// LoadConfig reads conf.env, applying account overrides and resolving the
+// cookies and keys through their secret providers.
+func LoadConfig(path string) error {
+	file, err := os.Open(path)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
+	env, err := parseEnv(file)
+	if err != nil {
+		return err
+	}
 
-	env := map[string]string{}
+	for _, game := range Games() {
+		prefix, config := game.EnvPrefix(), game.Account()
+		if uid := env[prefix+"_UID"]; uid != "" {
+			config.uid = uid
+		}
+		if server := env[prefix+"_SERVER"]; server != "" {
+			config.server = server
+		}
+		if region := env[prefix+"_REGION"]; region != "" {
+			config.region = region
+		}
+	}
+
+	for region, prefix := range map[Region]string{RegionOverseas: "HOYOLAB", RegionChina: "MIHOYO"} {
+		var values [3]string
+		for i, key := range []string{"_COOKIE", "_STOKEN", "_MID"} {
+			if values[i], err = lookupSecret(env, prefix+key); err != nil {
+				return err
+			}
+		}
+		credentials[region] = NewCredentials(region, values[0], values[1], values[2])
+		credentials[region].sealer = secretSealer(env, prefix+"_COOKIE")
+	}
+
+	if auth, err = loadAuth(env); err != nil {
+		return err
+	}
+	return nil
+}
 
-	for scanner.Scan() {
+func parseEnv(r io.Reader) (map[string]string, error) {
+	env := map[string]string{}
 
-		line := scanner.Text()
-		line = strings.TrimSpace(line)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
 
 		split := strings.SplitN(line, "=", 2)
 		if len(split) != 2 {
-			log.Fatal("couldnt parse " + line)
+			// the line itself may be a secret pasted without its key
+			return nil, fmt.Errorf("couldnt parse line %d", n)
 		}
 		env[split[0]] = split[1]
 	}
-
-	cookie := env["HOYOLAB_COOKIE"]
-	ZZZConfig.cookie = cookie
-	GenshinConfig.cookie = cookie
-	StarRailConfig.cookie = cookie
+	return env, scanner.Err()
 }
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	sendQueue    = flag.Int("send-queue", 256, "messages queued per client before it is dropped as too slow")
+	writeTimeout = flag.Duration("write-timeout", 10*time.Second, "how long a single write to a client may take")
+	pingInterval = flag.Duration("ping-interval", 30*time.Second, "how often websocket peers are pinged, 0 turns pings off")
+	pongTimeout  = flag.Duration("pong-timeout", 10*time.Second, "how long after a due ping a websocket peer may take to answer before it is dropped")
+)
+
+var (
+	errClientClosed = errors.New("client closed")
+	errSlowClient   = errors.New("client send queue is full")
+)
+
+// Client is one websocket or SSE connection and the topics it asked for.
+// Messages are queued and written by a single goroutine, so a client that
+// stops reading only ever holds up itself. One that falls a whole queue
+// behind is dropped.
+type Client struct {
+	// send writes one message to the connection, only the writer
+	// goroutine calls it
+	send func(data []byte) error
+	// shut closes the connection, telling the peer why when it can
+	shut func(code int, reason string)
+
+	// held while queueing, so a subscription's snapshot is queued before
+	// any event on the new topics
+	queueMu sync.Mutex
+	queue   chan []byte
+
+	closeOnce sync.Once
+	done      chan struct{}
+	stopped   chan struct{}
+
+	mu     sync.Mutex
+	topics map[string]bool
+}
+
+func newClient(send func([]byte) error, shut func(int, string), size int) *Client {
+	c := &Client{
+		send:    send,
+		shut:    shut,
+		queue:   make(chan []byte, max(size, 1)),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		topics:  map[string]bool{},
+	}
+	go c.run()
+	return c
+}
+
+func NewClient(conn *websocket.Conn) *Client {
+	send := func(data []byte) error {
+		conn.SetWriteDeadline(time.Now().Add(*writeTimeout))
+		return conn.WriteMessage(websocket.TextMessage, data)
+	}
+	shut := func(code int, reason string) { closeConn(conn, code, reason) }
+	return newClient(send, shut, *sendQueue)
+}
+
+// closeConn tells the peer why conn is closing and closes it. WriteControl
+// may run alongside the connection's writer.
+func closeConn(conn *websocket.Conn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	conn.Close()
+}
+
+func (c *Client) run() {
+	defer close(c.stopped)
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case data := <-c.queue:
+			if err := c.send(data); err != nil {
+				log.Println("client write:", err)
+				c.Close(websocket.CloseGoingAway, "")
+				return
+			}
+		}
+	}
+}
+
+// Write queues data for the client without waiting for it to be sent.
+func (c *Client) Write(data []byte) error {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	return c.enqueue(data)
+}
+
+// enqueue is Write for callers already holding queueMu.
+func (c *Client) enqueue(data []byte) error {
+	select {
+	case <-c.done:
+		return errClientClosed
+	default:
+	}
+
+	select {
+	case c.queue <- data:
+		return nil
+	default:
+		c.Close(websocket.CloseTryAgainLater, "too slow, send queue full")
+		return errSlowClient
+	}
+}
+
+func (c *Client) WriteEvent(topic string, payload any) error {
+	data, err := encodeEvent(topic, payload)
+	if err != nil {
+		return err
+	}
+	return c.Write(data)
+}
+
+// Close drops whatever is still queued and closes the connection.
+func (c *Client) Close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		if reason != "" {
+			log.Println("dropping client:", reason)
+		}
+		close(c.done)
+		c.shut(code, reason)
+	})
+}
+
+// Done is closed once the client is closed, by either side.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// heartbeat pings conn until done is closed. It must be called before the
+// first read: every pong pushes the read deadline back, so a peer that went
+// away without closing (a laptop asleep, a killed widget) fails its next
+// ReadMessage instead of lingering.
+func heartbeat(conn *websocket.Conn, done <-chan struct{}) {
+	interval, wait, timeout := *pingInterval, *pingInterval+*pongTimeout, *writeTimeout
+	if interval <= 0 {
+		return
+	}
+
+	conn.SetReadDeadline(time.Now().Add(wait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wait))
+	})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				// WriteControl may run alongside the connection's writer
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(timeout)); err != nil {
+					// the read deadline takes care of the rest
+					log.Println("ping:", err)
+					return
+				}
+			}
+		}
+	}()
+}
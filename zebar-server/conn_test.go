@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSlowClientIsDropped(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	var closedWith int
+	c := newClient(
+		func([]byte) error { <-unblock; return nil },
+		func(code int, reason string) { closedWith = code },
+		2,
+	)
+
+	// one message is being written, two wait in the queue
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = c.Write([]byte("{}"))
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !errors.Is(err, errSlowClient) {
+		t.Fatalf("err = %v, want %v", err, errSlowClient)
+	}
+	select {
+	case <-c.Done():
+	default:
+		t.Fatal("slow client was not closed")
+	}
+	if closedWith != websocket.CloseTryAgainLater {
+		t.Errorf("closed with %d", closedWith)
+	}
+	if err := c.Write([]byte("{}")); !errors.Is(err, errClientClosed) {
+		t.Errorf("write after close = %v", err)
+	}
+}
+
+func TestStuckConnectionTimesOut(t *testing.T) {
+	old := *writeTimeout
+	*writeTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { *writeTimeout = old })
+
+	serv := NewServer()
+	client, _ := dialServer(t, serv)
+
+	// the peer never reads, so the socket buffers fill up and a write blocks
+	big := []byte(`"` + strings.Repeat("x", 1<<20) + `"`)
+	deadline := time.After(10 * time.Second)
+	for {
+		select {
+		case <-client.Done():
+			return
+		case <-deadline:
+			t.Fatal("stuck client was never dropped")
+		case <-time.After(10 * time.Millisecond):
+			client.Write(big)
+		}
+	}
+}
+
+func TestCloseSendsCloseFrame(t *testing.T) {
+	serv := NewServer()
+	client, conn := dialServer(t, serv)
+
+	client.Close(websocket.CloseTryAgainLater, "too slow")
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseTryAgainLater) {
+		t.Errorf("err = %v, want a close frame", err)
+	}
+}
+
+func TestConcurrentPublishes(t *testing.T) {
+	serv := NewServer()
+	client, conn := dialServer(t, serv)
+	serv.Subscribe(client, []string{"stamina"})
+
+	const writers, each = 8, 25
+
+	var wg sync.WaitGroup
+	for range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range each {
+				publishEvent(t, serv, StaminaTopic(GENSHIN), StaminaPayload{Game: GENSHIN})
+			}
+		}()
+	}
+
+	for range writers * each {
+		readEvent(t, conn, StaminaTopic(GENSHIN))
+	}
+	wg.Wait()
+}
+
+func withHeartbeat(t *testing.T, interval, timeout time.Duration) {
+	oldInterval, oldTimeout := *pingInterval, *pongTimeout
+	*pingInterval, *pongTimeout = interval, timeout
+	t.Cleanup(func() { *pingInterval, *pongTimeout = oldInterval, oldTimeout })
+}
+
+// dials handler over a websocket
+func dialHandler(t *testing.T, handler http.HandlerFunc) *websocket.Conn {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(2 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHeartbeatDropsSilentPeer(t *testing.T) {
+	withHeartbeat(t, 50*time.Millisecond, 50*time.Millisecond)
+
+	serv := NewServer()
+	// never reading means pings are never answered
+	dialHandler(t, func(w http.ResponseWriter, r *http.Request) { serveWs(w, r, serv, nil) })
+
+	waitFor(t, "the client to be added", func() bool { return serv.ClientCount() == 1 })
+	waitFor(t, "the silent client to be dropped", func() bool { return serv.ClientCount() == 0 })
+}
+
+func TestHeartbeatKeepsLivePeer(t *testing.T) {
+	withHeartbeat(t, 50*time.Millisecond, 50*time.Millisecond)
+
+	serv := NewServer()
+	conn := dialHandler(t, func(w http.ResponseWriter, r *http.Request) { serveWs(w, r, serv, nil) })
+
+	// reading answers the pings
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	waitFor(t, "the client to be added", func() bool { return serv.ClientCount() == 1 })
+	time.Sleep(300 * time.Millisecond)
+	if serv.ClientCount() != 1 {
+		t.Error("a client answering pings was dropped")
+	}
+}
+
+func TestMediaSourceDisconnect(t *testing.T) {
+	serv := NewServer()
+	client, conn := dialServer(t, serv)
+	if err := serv.Subscribe(client, []string{TopicMedia}); err != nil {
+		t.Fatal(err)
+	}
+
+	source := dialHandler(t, func(w http.ResponseWriter, r *http.Request) { serveMediaSource(w, r, serv) })
+	if err := source.WriteMessage(websocket.TextMessage, []byte(`{"title":"song"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readEvent(t, conn, TopicMedia); msg["title"] != "song" {
+		t.Errorf("media = %v", msg)
+	}
+	if !serv.MediaConnected() {
+		t.Error("media source not connected")
+	}
+
+	source.Close()
+
+	if msg := readEvent(t, conn, TopicMedia); msg["kind"] != MediaSourceDisconnected {
+		t.Errorf("got %v, want the source disconnected", msg)
+	}
+	if serv.MediaConnected() {
+		t.Error("media source still connected")
+	}
+	if media, ok := serv.NowPlaying(); ok {
+		t.Errorf("still playing %s", media)
+	}
+	if err := serv.TogglePlayback(); !errors.Is(err, errNoMediaSource) {
+		t.Errorf("toggle = %v", err)
+	}
+}
+
+func TestTogglePlaybackReachesSource(t *testing.T) {
+	serv := NewServer()
+	source := dialHandler(t, func(w http.ResponseWriter, r *http.Request) { serveMediaSource(w, r, serv) })
+	waitFor(t, "the source", serv.MediaConnected)
+
+	if err := serv.TogglePlayback(); err != nil {
+		t.Fatal(err)
+	}
+
+	source.SetReadDeadline(time.Now().Add(2 * time.Second))
+	mtype, b, err := source.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mtype != websocket.TextMessage || len(b) != 0 {
+		t.Errorf("got type %d %q, want an empty text message", mtype, b)
+	}
+}
+
+func TestReplacedMediaSourceStaysConnected(t *testing.T) {
+	serv := NewServer()
+	handler := func(w http.ResponseWriter, r *http.Request) { serveMediaSource(w, r, serv) }
+
+	old := dialHandler(t, handler)
+	waitFor(t, "the first source", serv.MediaConnected)
+	serv.mu.Lock()
+	first := serv.ytmusic
+	serv.mu.Unlock()
+	dialHandler(t, handler)
+	waitFor(t, "the second source", func() bool {
+		serv.mu.Lock()
+		defer serv.mu.Unlock()
+		return serv.ytmusic != nil && serv.ytmusic != first
+	})
+
+	old.Close()
+	time.Sleep(100 * time.Millisecond)
+	if !serv.MediaConnected() {
+		t.Error("closing the replaced source disconnected the new one")
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	AlertCookieExpiring = "cookie_expiring"
+	AlertCookieInvalid  = "cookie_invalid"
+	AlertCookieOk       = "cookie_ok"
+
+	retcodeNotLoggedIn   = -100
+	retcodeRecordLogin   = 10001
+	stokenAppId          = "c9oqaq3s3gu8"
+	cookieHealthInterval = 6 * time.Hour
+)
+
+var (
+	cookieLifetime = flag.Duration("cookie-lifetime", 30*24*time.Hour, "how long a pasted HoYoLAB cookie is expected to keep working, counted from when zbserv first saw it as the real issue time is unknown")
+	cookieWarn     = flag.Duration("cookie-warn", 3*24*time.Hour, "how far ahead of the expected expiry to warn clients")
+)
+
+type tokenEndpoints struct {
+	ltoken      string
+	cookieToken string
+	roles       string
+}
+
+var credentialEndpoints = map[Region]tokenEndpoints{
+	RegionOverseas: {
+		ltoken:      "https://sg-public-api.hoyoverse.com/account/auth/api/getLTokenBySToken",
+		cookieToken: "https://sg-public-api.hoyoverse.com/account/auth/api/getCookieAccountInfoBySToken",
+		roles:       "https://api-account-os.hoyolab.com/account/binding/api/getUserGameRolesByCookie",
+	},
+	RegionChina: {
+		ltoken:      "https://passport-api.mihoyo.com/account/auth/api/getLTokenBySToken",
+		cookieToken: "https://api-takumi.mihoyo.com/auth/api/getCookieAccountInfoBySToken",
+		roles:       "https://api-takumi.mihoyo.com/binding/api/getUserGameRolesByCookie",
+	},
+}
+
+var errNoStoken = errors.New("no stoken configured")
+
+// Credentials holds the cookie for one region. The cookie pasted into
+// conf.env is the starting point, when an stoken/mid pair is configured it
+// is exchanged for fresh tokens whenever HoYoLAB rejects the current ones.
+type Credentials struct {
+	mu     sync.Mutex
+	region Region
+	path   string
+
+	// held for a whole refresh, so accounts rejected together exchange the
+	// stoken once
+	refreshMu sync.Mutex
+
+	// the cookie from conf.env, value is the refreshed one once it was
+	base     string
+	value    string
+	issuedAt int64
+	// protects the refreshed cookie in the state file, nil keeps it out
+	sealer sealer
+
+	stoken string
+	mid    string
+
+	alert   *CookieAlert
+	publish func(topic string, data []byte)
+}
+
+type CookieAlert struct {
+	Kind      string `json:"kind"`
+	Id        string `json:"id"`
+	Region    Region `json:"region"`
+	ExpiresAt int64  `json:"expiresAt,omitempty"`
+	Message   string `json:"message"`
+}
+
+var credentials = map[Region]*Credentials{}
+
+func NewCredentials(region Region, cookie, stoken, mid string) *Credentials {
+	return &Credentials{
+		region:   region,
+		base:     cookie,
+		value:    cookie,
+		issuedAt: time.Now().Unix(),
+		sealer:   plainSealer{},
+		stoken:   stoken,
+		mid:      mid,
+	}
+}
+
+// savedCredentials is the state file. The configured cookie is never written,
+// only a hash to notice when a new one was pasted.
+type savedCredentials struct {
+	BaseHash string `json:"baseHash"`
+	IssuedAt int64  `json:"issuedAt"`
+	// the refreshed cookie as sealed, empty while the configured one is used
+	Cookie []byte `json:"cookie,omitempty"`
+}
+
+func cookieHash(cookie string) string {
+	sum := sha256.Sum256([]byte(cookie))
+	return hex.EncodeToString(sum[:])
+}
+
+// Load restores a previously refreshed cookie. A different cookie in
+// conf.env means the user pasted a new one, which wins over the saved state.
+func (c *Credentials) Load(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.path = path
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c.save()
+	}
+	if err != nil {
+		return err
+	}
+
+	var saved savedCredentials
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return err
+	}
+	// also replaces state files from before the hash, which held the cookie
+	if saved.BaseHash != cookieHash(c.base) {
+		return c.save()
+	}
+
+	c.issuedAt = saved.IssuedAt
+	if len(saved.Cookie) == 0 || c.sealer == nil {
+		return nil
+	}
+	cookie, err := c.sealer.open(saved.Cookie)
+	if err != nil {
+		return err
+	}
+	c.value = string(cookie)
+	registerSecret(c.value)
+	return nil
+}
+
+func (c *Credentials) save() error {
+	if c.path == "" {
+		return nil
+	}
+
+	saved := savedCredentials{BaseHash: cookieHash(c.base), IssuedAt: c.issuedAt}
+	if c.value != c.base {
+		// the configured cookie's state stays as it was, a restart uses it
+		// again until the next refresh
+		if c.sealer == nil {
+			return nil
+		}
+		sealed, err := c.sealer.seal([]byte(c.value))
+		if err != nil {
+			return err
+		}
+		saved.Cookie = sealed
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, b, 0o600)
+}
+
+func (c *Credentials) Cookie() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.value
+}
+
+func (c *Credentials) CanRefresh() bool {
+	return c.stoken != "" && c.mid != ""
+}
+
+func (c *Credentials) ExpiresAt() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return time.Unix(c.issuedAt, 0).Add(*cookieLifetime)
+}
+
+func (c *Credentials) SetPublisher(publish func(topic string, data []byte)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.publish = publish
+}
+
+func (c *Credentials) Alert() *CookieAlert {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.alert
+}
+
+func (c *Credentials) Refresh(ctx context.Context) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	return c.refresh(ctx)
+}
+
+// RefreshRejected refreshes the cookie after HoYoLAB turned down the one in
+// rejected, unless another request already refreshed it since.
+func (c *Credentials) RefreshRejected(ctx context.Context, rejected string) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	if c.Cookie() != rejected {
+		return nil
+	}
+	return c.refresh(ctx)
+}
+
+func (c *Credentials) refresh(ctx context.Context) error {
+	if !c.CanRefresh() {
+		return errNoStoken
+	}
+
+	endpoints := credentialEndpoints[c.region]
+	stokenCookie := fmt.Sprintf("stoken=%s; mid=%s", c.stoken, c.mid)
+
+	var ltoken struct {
+		Ltoken string `json:"ltoken"`
+	}
+	if err := stokenRequest(ctx, endpoints.ltoken, stokenCookie, &ltoken); err != nil {
+		return err
+	}
+
+	var account struct {
+		Uid         string `json:"uid"`
+		CookieToken string `json:"cookie_token"`
+	}
+	if err := stokenRequest(ctx, endpoints.cookieToken, stokenCookie, &account); err != nil {
+		return err
+	}
+	if ltoken.Ltoken == "" || account.CookieToken == "" {
+		return errors.New("stoken exchange returned no tokens")
+	}
+
+	cookie := fmt.Sprintf(
+		"ltoken_v2=%s; ltuid_v2=%s; ltmid_v2=%s; cookie_token_v2=%s; account_id_v2=%s; account_mid_v2=%s",
+		ltoken.Ltoken, account.Uid, c.mid, account.CookieToken, account.Uid, c.mid,
+	)
+
+	c.mu.Lock()
+	c.value = cookie
+	c.issuedAt = time.Now().Unix()
+	err := c.save()
+	c.mu.Unlock()
+
+	log.Println("refreshed", c.region, "cookie from stoken")
+	c.setAlert(AlertCookieOk, "cookie refreshed")
+
+	return err
+}
+
+func stokenRequest(ctx context.Context, url, cookie string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Cookie", cookie)
+	req.Header.Set("x-rpc-app_id", stokenAppId)
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+
+	_, b, err := hoyo.Do(req)
+	if err != nil {
+		return err
+	}
+
+	var result recordResponse
+	if err := json.Unmarshal(b, &result); err != nil {
+		return err
+	}
+	if result.Retcode != 0 {
+		return fmt.Errorf("stoken exchange failed: %d %s", result.Retcode, result.Message)
+	}
+	return json.Unmarshal(result.Data, v)
+}
+
+// Valid asks HoYoLAB whether the cookie still logs in.
+func (c *Credentials) Valid(ctx context.Context) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", credentialEndpoints[c.region].roles, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Cookie", c.Cookie())
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+
+	_, b, err := hoyo.Do(req)
+	if err != nil {
+		return false, err
+	}
+
+	var result recordResponse
+	if err := json.Unmarshal(b, &result); err != nil {
+		return false, err
+	}
+	return !cookieRejected(result.Retcode), nil
+}
+
+func cookieRejected(retcode int) bool {
+	return retcode == retcodeNotLoggedIn || retcode == retcodeRecordLogin
+}
+
+// CheckHealth refreshes credentials that are rejected or close to expiring
+// and warns clients when that is not possible.
+func (c *Credentials) CheckHealth(ctx context.Context, now time.Time) {
+	valid, err := c.Valid(ctx)
+	if err != nil {
+		log.Println("cookie health:", err)
+		return
+	}
+
+	expiring := now.After(c.ExpiresAt().Add(-*cookieWarn))
+	if valid && !expiring {
+		c.setAlert(AlertCookieOk, "")
+		return
+	}
+
+	if err := c.Refresh(ctx); err == nil {
+		return
+	} else if !errors.Is(err, errNoStoken) {
+		log.Println("cookie refresh:", err)
+	}
+
+	if !valid {
+		c.setAlert(AlertCookieInvalid, "HoYoLAB rejected the cookie, paste a new one into conf.env")
+		return
+	}
+	c.setAlert(AlertCookieExpiring, "the HoYoLAB cookie is about to expire, paste a new one into conf.env or configure an stoken")
+}
+
+func (c *Credentials) setAlert(kind, message string) {
+	c.mu.Lock()
+	prev := c.alert
+	alert := &CookieAlert{Kind: kind, Id: "cookie:" + c.region, Region: c.region, Message: message}
+	if kind == AlertCookieExpiring {
+		alert.ExpiresAt = time.Unix(c.issuedAt, 0).Add(*cookieLifetime).Unix()
+	}
+	if kind == AlertCookieOk {
+		c.alert = nil
+	} else {
+		c.alert = alert
+	}
+	publish := c.publish
+	c.mu.Unlock()
+
+	if kind != AlertCookieOk && (prev == nil || prev.Kind != kind) {
+		alerts.Raised(alert.Id)
+	}
+
+	// only tell clients about changes, and only about recoveries from a
+	// previous warning
+	if publish == nil || (prev == nil && kind == AlertCookieOk) || (prev != nil && prev.Kind == kind) {
+		return
+	}
+
+	data, err := encodeEvent(TopicAlerts, *alert)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	publish(TopicAlerts, data)
+}
+
+func LoadCredentials(dir string) {
+	for region, c := range credentials {
+		if err := c.Load(filepath.Join(dir, "cookie_"+region+".json")); err != nil {
+			log.Println("credentials:", err)
+		}
+	}
+}
+
+func RunCookieHealth(ctx context.Context) {
+	ticker := time.NewTicker(cookieHealthInterval)
+	defer ticker.Stop()
+
+	for {
+		for region, c := range credentials {
+			if c.Cookie() == "" || len(accountsIn(region)) == 0 {
+				continue
+			}
+			c.CheckHealth(ctx, time.Now())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func accountsIn(region Region) []GameConfig {
+	var accounts []GameConfig
+	for _, config := range Configs() {
+		if config.Region() == region {
+			accounts = append(accounts, config)
+		}
+	}
+	return accounts
+}
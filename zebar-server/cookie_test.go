@@ -0,0 +1,303 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeAccount struct {
+	mu        sync.Mutex
+	valid     string
+	exchanges int
+	stoken    string
+}
+
+func (f *fakeAccount) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/getLTokenBySToken"):
+		f.exchanges++
+		f.stoken = r.Header.Get("Cookie")
+		io.WriteString(w, `{"retcode":0,"message":"OK","data":{"ltoken":"v2_fresh"}}`)
+	case strings.HasSuffix(r.URL.Path, "/getCookieAccountInfoBySToken"):
+		f.valid = "cookie_token_v2=ctoken"
+		io.WriteString(w, `{"retcode":0,"message":"OK","data":{"uid":"1234","cookie_token":"ctoken"}}`)
+	default:
+		if f.valid == "" || !strings.Contains(r.Header.Get("Cookie"), f.valid) {
+			io.WriteString(w, `{"retcode":-100,"message":"Please login","data":null}`)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/getUserGameRolesByCookie") {
+			io.WriteString(w, `{"retcode":0,"message":"OK","data":{"list":[]}}`)
+			return
+		}
+		b, _ := os.ReadFile("testdata/genshin_note.json")
+		w.Write(b)
+	}
+}
+
+func withFakeAccount(t *testing.T, fake *fakeAccount, creds *Credentials) *[]map[string]any {
+	t.Helper()
+	withTestAccount(t)
+
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	oldEndpoints, oldRecord := credentialEndpoints[RegionOverseas], recordURL
+	credentialEndpoints[RegionOverseas] = tokenEndpoints{
+		ltoken:      srv.URL + "/getLTokenBySToken",
+		cookieToken: srv.URL + "/getCookieAccountInfoBySToken",
+		roles:       srv.URL + "/getUserGameRolesByCookie",
+	}
+	recordURL = srv.URL
+	t.Cleanup(func() {
+		credentialEndpoints[RegionOverseas] = oldEndpoints
+		recordURL = oldRecord
+	})
+
+	oldCreds := credentials[RegionOverseas]
+	credentials[RegionOverseas] = creds
+	t.Cleanup(func() { credentials[RegionOverseas] = oldCreds })
+
+	withCaptchaGate(t)
+
+	var mu sync.Mutex
+	published := []map[string]any{}
+	creds.SetPublisher(func(topic string, b []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		published = append(published, decodeEvent(t, b, TopicAlerts))
+	})
+	return &published
+}
+
+func TestRejectedCookieRefreshesFromStoken(t *testing.T) {
+	fake := &fakeAccount{}
+	creds := NewCredentials(RegionOverseas, "ltoken_v2=stale", "v2_stoken", "mid-1")
+	withFakeAccount(t, fake, creds)
+
+	path := filepath.Join(t.TempDir(), "cookie_os.json")
+	if err := creds.Load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	config := GameConfig{game: GENSHIN, uid: "1", server: "os_usa"}
+	if _, err := DailyNote(t.Context(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	if fake.exchanges != 1 {
+		t.Errorf("stoken exchanged %d times, want 1", fake.exchanges)
+	}
+	if fake.stoken != "stoken=v2_stoken; mid=mid-1" {
+		t.Errorf("stoken cookie %q", fake.stoken)
+	}
+	want := "ltoken_v2=v2_fresh; ltuid_v2=1234; ltmid_v2=mid-1; cookie_token_v2=ctoken; account_id_v2=1234; account_mid_v2=mid-1"
+	if got := config.Cookie(); got != want {
+		t.Errorf("cookie = %q, want %q", got, want)
+	}
+
+	// the refreshed cookie survives a restart as long as conf.env is unchanged
+	restarted := NewCredentials(RegionOverseas, "ltoken_v2=stale", "", "")
+	if err := restarted.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if restarted.Cookie() != want {
+		t.Errorf("restored cookie = %q", restarted.Cookie())
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("cookie state mode = %v", info.Mode().Perm())
+	}
+	if state, _ := os.ReadFile(path); strings.Contains(string(state), "ltoken_v2=stale") {
+		t.Errorf("state file holds the configured cookie: %s", state)
+	}
+
+	// a new cookie pasted into conf.env replaces the saved one
+	pasted := NewCredentials(RegionOverseas, "ltoken_v2=pasted", "", "")
+	if err := pasted.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if pasted.Cookie() != "ltoken_v2=pasted" {
+		t.Errorf("pasted cookie = %q", pasted.Cookie())
+	}
+}
+
+func TestSimultaneousRejectionsRefreshOnce(t *testing.T) {
+	fake := &fakeAccount{}
+	creds := NewCredentials(RegionOverseas, "ltoken_v2=stale", "v2_stoken", "mid-1")
+	withFakeAccount(t, fake, creds)
+
+	// both accounts are rejected before either refreshes
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	var once sync.Map
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, seen := once.LoadOrStore(r.URL.Query().Get("role_id"), true); !seen {
+			arrived.Done()
+			arrived.Wait()
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	recordURL = srv.URL
+
+	var fetched sync.WaitGroup
+	for _, uid := range []string{"1", "2"} {
+		fetched.Add(1)
+		go func() {
+			defer fetched.Done()
+			if _, err := DailyNote(t.Context(), GameConfig{game: GENSHIN, uid: uid, server: "os_usa"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	fetched.Wait()
+
+	if fake.exchanges != 1 {
+		t.Errorf("stoken exchanged %d times, want 1", fake.exchanges)
+	}
+}
+
+func TestCookieStateIsSealed(t *testing.T) {
+	fake := &fakeAccount{}
+	creds := NewCredentials(RegionOverseas, "ltoken_v2=from-enc-file", "v2_stoken", "mid-1")
+	sealer := passphraseSealer{passphrase: func() (string, error) { return "hunter2", nil }}
+	creds.sealer = sealer
+	withFakeAccount(t, fake, creds)
+
+	path := filepath.Join(t.TempDir(), "cookie_os.json")
+	if err := creds.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := creds.Refresh(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"from-enc-file", "v2_fresh", "ctoken"} {
+		if strings.Contains(string(state), secret) {
+			t.Errorf("state file holds %q: %s", secret, state)
+		}
+	}
+
+	restarted := NewCredentials(RegionOverseas, "ltoken_v2=from-enc-file", "", "")
+	restarted.sealer = sealer
+	if err := restarted.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if restarted.Cookie() != creds.Cookie() {
+		t.Errorf("restored cookie = %q", restarted.Cookie())
+	}
+
+	// from a provider that cannot encrypt, the refreshed cookie is not kept
+	// and the state is left alone
+	unsealed := NewCredentials(RegionOverseas, "ltoken_v2=from-enc-file", "v2_stoken", "mid-1")
+	unsealed.sealer = nil
+	withFakeAccount(t, fake, unsealed)
+	if err := unsealed.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if unsealed.Cookie() != "ltoken_v2=from-enc-file" {
+		t.Errorf("cookie = %q", unsealed.Cookie())
+	}
+	if err := unsealed.Refresh(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.ReadFile(path); string(again) != string(state) {
+		t.Errorf("state rewritten without a sealer: %s", again)
+	}
+}
+
+func TestRejectedCookieWithoutStoken(t *testing.T) {
+	fake := &fakeAccount{}
+	creds := NewCredentials(RegionOverseas, "ltoken_v2=stale", "", "")
+	withFakeAccount(t, fake, creds)
+
+	_, err := DailyNote(t.Context(), GameConfig{game: GENSHIN, uid: "1", server: "os_usa"})
+
+	apiErr, ok := err.(*APIError)
+	if !ok || apiErr.Retcode != retcodeNotLoggedIn {
+		t.Fatalf("err = %v, want a -100 APIError", err)
+	}
+	if fake.exchanges != 0 {
+		t.Errorf("stoken exchanged %d times without an stoken", fake.exchanges)
+	}
+}
+
+func TestCookieHealthAlerts(t *testing.T) {
+	fake := &fakeAccount{}
+	creds := NewCredentials(RegionOverseas, "ltoken_v2=stale", "", "")
+	published := withFakeAccount(t, fake, creds)
+
+	now := time.Now()
+	creds.CheckHealth(t.Context(), now)
+
+	if alert := creds.Alert(); alert == nil || alert.Kind != AlertCookieInvalid {
+		t.Fatalf("alert = %+v, want %s", alert, AlertCookieInvalid)
+	}
+
+	// the same alert is not published twice
+	creds.CheckHealth(t.Context(), now)
+	if len(*published) != 1 {
+		t.Fatalf("published %d alerts, want 1", len(*published))
+	}
+	if msg := (*published)[0]; msg["kind"] != AlertCookieInvalid || msg["region"] != RegionOverseas {
+		t.Errorf("alert message = %v", msg)
+	}
+
+	// a working cookie near the end of its lifetime warns ahead of time
+	fake.valid = "ltoken_v2=stale"
+	creds.CheckHealth(t.Context(), now.Add(*cookieLifetime-time.Hour))
+
+	alert := creds.Alert()
+	if alert == nil || alert.Kind != AlertCookieExpiring {
+		t.Fatalf("alert = %+v, want %s", alert, AlertCookieExpiring)
+	}
+	if alert.ExpiresAt != creds.ExpiresAt().Unix() {
+		t.Errorf("expiresAt = %d, want %d", alert.ExpiresAt, creds.ExpiresAt().Unix())
+	}
+
+	creds.CheckHealth(t.Context(), now)
+	if creds.Alert() != nil {
+		t.Errorf("alert = %+v after recovering", creds.Alert())
+	}
+	if len(*published) != 3 || (*published)[2]["kind"] != AlertCookieOk {
+		t.Errorf("published = %v", *published)
+	}
+}
+
+func TestCookieHealthRefreshesBeforeExpiry(t *testing.T) {
+	fake := &fakeAccount{valid: "ltoken_v2=stale"}
+	creds := NewCredentials(RegionOverseas, "ltoken_v2=stale", "v2_stoken", "mid-1")
+	published := withFakeAccount(t, fake, creds)
+
+	creds.CheckHealth(t.Context(), time.Now().Add(*cookieLifetime))
+
+	if fake.exchanges != 1 {
+		t.Errorf("stoken exchanged %d times, want 1", fake.exchanges)
+	}
+	if creds.Alert() != nil || len(*published) != 0 {
+		t.Errorf("alert = %+v, published = %v", creds.Alert(), *published)
+	}
+	if !creds.ExpiresAt().After(time.Now().Add(*cookieLifetime - time.Minute)) {
+		t.Errorf("expiry was not pushed back: %v", creds.ExpiresAt())
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	fpURL   = "https://sg-public-data-api.hoyoverse.com/device-fp/api/getFp"
+	cnFpURL = "https://public-data-api.mihoyo.com/device-fp/api/getFp"
+)
+
+// Device is the identity zbserv presents to HoYoLAB. The id is generated
+// once and kept across restarts, fingerprints are fetched per region and
+// replaced when HoYoLAB stops accepting them.
+type Device struct {
+	mu   sync.Mutex
+	path string
+
+	Id  string            `json:"deviceId"`
+	Fps map[Region]string `json:"deviceFp"`
+}
+
+var (
+	deviceMu sync.Mutex
+	device   *Device
+)
+
+// currentDevice loads the device from the state dir the first time it is
+// needed, unless one was already set.
+func currentDevice() *Device {
+	deviceMu.Lock()
+	defer deviceMu.Unlock()
+
+	if device == nil {
+		d, err := LoadDevice(filepath.Join(*stateDir, "device.json"))
+		if err != nil {
+			log.Println("device:", err)
+		}
+		device = d
+	}
+	return device
+}
+
+func LoadDevice(path string) (*Device, error) {
+	d := &Device{path: path, Fps: map[Region]string{}}
+
+	b, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(b, d); err != nil {
+			return d, err
+		}
+	} else if !os.IsNotExist(err) {
+		return d, err
+	}
+
+	if d.Fps == nil {
+		d.Fps = map[Region]string{}
+	}
+	if d.Id == "" {
+		d.Id = newDeviceId()
+		return d, d.save()
+	}
+	return d, nil
+}
+
+func (d *Device) save() error {
+	if d.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(d.path), 0o700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(d.path, b, 0o600)
+}
+
+func newDeviceId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func randomHex(n int) string {
+	b := make([]byte, (n+1)/2)
+	rand.Read(b)
+	return hex.EncodeToString(b)[:n]
+}
+
+// Fingerprint returns the cached fingerprint for region, fetching one the
+// first time it is needed.
+func (d *Device) Fingerprint(ctx context.Context, region Region) (string, error) {
+	d.mu.Lock()
+	fp := d.Fps[region]
+	d.mu.Unlock()
+
+	if fp != "" {
+		return fp, nil
+	}
+	return d.RefreshFingerprint(ctx, region)
+}
+
+// RefreshFingerprint fetches a new fingerprint for region. The lock is not
+// held while HoYoLAB answers, so requests for other regions are not held up.
+func (d *Device) RefreshFingerprint(ctx context.Context, region Region) (string, error) {
+	d.mu.Lock()
+	req, err := fpRequest(ctx, d.Id, region)
+	d.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	fp, err := getFp(req, region)
+	if err != nil {
+		return "", err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.Fps[region] = fp
+	if err := d.save(); err != nil {
+		log.Println("device:", err)
+	}
+	return fp, nil
+}
+
+type getFpResponse struct {
+	Retcode int    `json:"retcode"`
+	Message string `json:"message"`
+	Data    struct {
+		DeviceFp string `json:"device_fp"`
+		Code     int    `json:"code"`
+		Msg      string `json:"msg"`
+	} `json:"data"`
+}
+
+func fpRequest(ctx context.Context, deviceId string, region Region) (*http.Request, error) {
+	endpoint, appName := fpURL, "bbs_oversea"
+	if region == RegionChina {
+		endpoint, appName = cnFpURL, "bbs_cn"
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"device_id":  deviceId,
+		"seed_id":    newDeviceId(),
+		"seed_time":  strconv.FormatInt(time.Now().UnixMilli(), 10),
+		"platform":   "5",
+		"device_fp":  randomHex(13),
+		"app_name":   appName,
+		"ext_fields": `{"userAgent":"Mozilla/5.0","browserScreenSize":2073600,"maxTouchPoints":0,"isTouchSupported":false}`,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	return req, nil
+}
+
+func getFp(req *http.Request, region Region) (string, error) {
+	_, b, err := hoyo.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	var result getFpResponse
+	if err := json.Unmarshal(b, &result); err != nil {
+		return "", err
+	}
+	if result.Retcode != 0 || result.Data.Code != 200 || result.Data.DeviceFp == "" {
+		return "", fmt.Errorf("getFp failed: %d %s %s", result.Retcode, result.Message, result.Data.Msg)
+	}
+
+	log.Println("fetched device fingerprint for", region)
+	return result.Data.DeviceFp, nil
+}
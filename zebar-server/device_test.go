@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withTestAccount gives the test a device with fingerprints and a cookie
+// for each region, so it never talks to the real getFp endpoint or writes to
+// the state dir, and runs without a conf.env.
+func withTestAccount(t *testing.T) {
+	t.Helper()
+
+	withDevice(t, &Device{
+		Id:  "00000000-0000-4000-8000-000000000000",
+		Fps: map[Region]string{RegionOverseas: "test-fp", RegionChina: "test-fp"},
+	})
+
+	old := credentials
+	credentials = map[Region]*Credentials{
+		RegionOverseas: NewCredentials(RegionOverseas, "ltoken_v2=test", "", ""),
+		RegionChina:    NewCredentials(RegionChina, "ltoken=test", "", ""),
+	}
+	t.Cleanup(func() { credentials = old })
+}
+
+type fakeFp struct {
+	fp       string
+	calls    int
+	deviceId string
+	appName  string
+}
+
+func (f *fakeFp) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]string
+	b, _ := io.ReadAll(r.Body)
+	json.Unmarshal(b, &body)
+
+	f.calls++
+	f.deviceId = body["device_id"]
+	f.appName = body["app_name"]
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"retcode": 0,
+		"message": "OK",
+		"data":    map[string]any{"device_fp": f.fp, "code": 200, "msg": "ok"},
+	})
+}
+
+func withFakeFp(t *testing.T, fake *fakeFp) {
+	t.Helper()
+
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	old, oldCN := fpURL, cnFpURL
+	fpURL, cnFpURL = srv.URL, srv.URL
+	t.Cleanup(func() { fpURL, cnFpURL = old, oldCN })
+}
+
+func withCaptchaGate(t *testing.T) {
+	t.Helper()
+
+	old := captchas
+	captchas = NewCaptchaGate()
+	t.Cleanup(func() { captchas = old })
+}
+
+func withDevice(t *testing.T, d *Device) {
+	t.Helper()
+
+	deviceMu.Lock()
+	old := device
+	device = d
+	deviceMu.Unlock()
+	t.Cleanup(func() {
+		deviceMu.Lock()
+		device = old
+		deviceMu.Unlock()
+	})
+}
+
+func TestLoadDevicePersistsId(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "device.json")
+
+	d, err := LoadDevice(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Id) != 36 {
+		t.Errorf("device id %q is not a uuid", d.Id)
+	}
+
+	again, err := LoadDevice(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Id != d.Id {
+		t.Errorf("device id changed across loads: %s != %s", again.Id, d.Id)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		t.Errorf("device file is readable by others: %s", info.Mode())
+	}
+}
+
+func TestDeviceFingerprint(t *testing.T) {
+	fake := &fakeFp{fp: "38d7f0fa53a2e"}
+	withFakeFp(t, fake)
+
+	path := filepath.Join(t.TempDir(), "device.json")
+	d, err := LoadDevice(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		fp, err := d.Fingerprint(t.Context(), RegionOverseas)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fp != "38d7f0fa53a2e" {
+			t.Errorf("fp = %s", fp)
+		}
+	}
+	if fake.calls != 1 || fake.deviceId != d.Id || fake.appName != "bbs_oversea" {
+		t.Errorf("getFp calls = %d, device_id = %s, app_name = %s", fake.calls, fake.deviceId, fake.appName)
+	}
+
+	reloaded, err := LoadDevice(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Fps[RegionOverseas] != "38d7f0fa53a2e" {
+		t.Errorf("fingerprint was not persisted: %v", reloaded.Fps)
+	}
+}
+
+func TestRefreshDoesNotBlockOtherRegions(t *testing.T) {
+	arrived, release := make(chan struct{}), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-release
+		(&fakeFp{fp: "fresh-fp"}).ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	old := fpURL
+	fpURL = srv.URL
+	t.Cleanup(func() { fpURL = old })
+
+	d := &Device{Id: "device", Fps: map[Region]string{RegionChina: "cn-fp"}}
+	refreshed := make(chan error, 1)
+	go func() {
+		_, err := d.RefreshFingerprint(t.Context(), RegionOverseas)
+		refreshed <- err
+	}()
+	<-arrived
+
+	got := make(chan string, 1)
+	go func() {
+		fp, _ := d.Fingerprint(t.Context(), RegionChina)
+		got <- fp
+	}()
+	select {
+	case fp := <-got:
+		if fp != "cn-fp" {
+			t.Errorf("fp = %s", fp)
+		}
+	case <-time.After(time.Second):
+		t.Error("waited on another region's refresh")
+	}
+
+	close(release)
+	if err := <-refreshed; err != nil {
+		t.Fatal(err)
+	}
+	if fp, _ := d.Fingerprint(t.Context(), RegionOverseas); fp != "fresh-fp" {
+		t.Errorf("fp = %s after the refresh", fp)
+	}
+}
+
+func TestRejectedFingerprintIsRefreshed(t *testing.T) {
+	withTestAccount(t)
+	fake := &fakeFp{fp: "fresh-fp"}
+	withFakeFp(t, fake)
+	withDevice(t, &Device{Id: "device", Fps: map[Region]string{RegionOverseas: "stale-fp"}})
+
+	var seen []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Get("x-rpc-device_fp"))
+		if r.Header.Get("x-rpc-device_id") != "device" {
+			t.Errorf("device id header = %q", r.Header.Get("x-rpc-device_id"))
+		}
+		if r.Header.Get("x-rpc-device_fp") != "fresh-fp" {
+			w.Write([]byte(`{"retcode":1034,"message":"","data":null}`))
+			return
+		}
+		b, _ := os.ReadFile("testdata/genshin_note.json")
+		w.Write(b)
+	}))
+	defer srv.Close()
+
+	old := recordURL
+	recordURL = srv.URL
+	defer func() { recordURL = old }()
+
+	note, err := DailyNote(t.Context(), GenshinConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note.Current != 112 {
+		t.Errorf("note = %+v", note)
+	}
+	if len(seen) != 2 || seen[0] != "stale-fp" || seen[1] != "fresh-fp" {
+		t.Errorf("fingerprints sent = %v", seen)
+	}
+	if fake.calls != 1 {
+		t.Errorf("getFp calls = %d, want 1", fake.calls)
+	}
+}
+
+func TestRejectedTwiceReturnsError(t *testing.T) {
+	withTestAccount(t)
+	withFakeFp(t, &fakeFp{fp: "fresh-fp"})
+	withCaptchaGate(t)
+	withDevice(t, &Device{Id: "device", Fps: map[Region]string{}})
+
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the verification the second rejection asks for is not a record call
+		if !strings.Contains(r.URL.Path, "/card/wapi/") {
+			calls++
+		}
+		w.Write([]byte(`{"retcode":1034,"message":"","data":null}`))
+	}))
+	defer srv.Close()
+
+	old, oldVerification := recordURL, verificationURL
+	recordURL, verificationURL = srv.URL, srv.URL+"/card/wapi"
+	defer func() { recordURL, verificationURL = old, oldVerification }()
+
+	_, err := DailyNote(t.Context(), GenshinConfig)
+	apiErr, ok := err.(*APIError)
+	if !ok || apiErr.Retcode != retcodeCaptcha {
+		t.Fatalf("err = %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("record calls = %d, want 2", calls)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DS2 salt for CN game record endpoints (X4)
+	dsSaltCN = "xV8v4Qu54lUKrEYFZkJhB8cuOh9Asafs"
+	// DS2 salt for CN endpoints signed with a JSON body (X6)
+	dsSaltCNBody = "t0qEgfub6cvueAPgR5m9aQWWVciEer7v"
+)
+
+func generateDS() string {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+	random := make([]byte, 6)
+	for i := range 6 {
+		random[i] = letters[rand.Intn(len(letters))]
+	}
+
+	return ds1(dsSalt, time.Now().Unix(), string(random))
+}
+
+func ds1(salt string, t int64, random string) string {
+	raw := fmt.Sprintf("salt=%s&t=%d&r=%s", salt, t, random)
+	hash := fmt.Sprintf("%x", md5.Sum([]byte(raw)))
+
+	return fmt.Sprintf("%d,%s,%s", t, random, hash)
+}
+
+// generateDS2 signs the request body and query, which the CN servers check
+// against the values actually sent.
+func generateDS2(salt, body string, query url.Values) string {
+	return ds2(salt, time.Now().Unix(), rand.Intn(100000)+100001, body, query)
+}
+
+func ds2(salt string, t int64, random int, body string, query url.Values) string {
+	raw := fmt.Sprintf("salt=%s&t=%d&r=%d&b=%s&q=%s", salt, t, random, body, ds2Query(query))
+	hash := fmt.Sprintf("%x", md5.Sum([]byte(raw)))
+
+	return fmt.Sprintf("%d,%d,%s", t, random, hash)
+}
+
+// the query is signed unescaped with its keys sorted
+func ds2Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, k+"="+v)
+		}
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDS1(t *testing.T) {
+	got := ds1(dsSalt, 1750000000, "abcDEF")
+	want := "1750000000,abcDEF,ffd4eeb184e6a58bfeaf143b2c99a08b"
+	if got != want {
+		t.Errorf("ds1 = %s, want %s", got, want)
+	}
+}
+
+func TestDS2(t *testing.T) {
+	tests := []struct {
+		salt   string
+		t      int64
+		random int
+		body   string
+		query  url.Values
+		want   string
+	}{
+		{
+			dsSaltCN, 1750000000, 123456, "",
+			url.Values{"server": {"cn_gf01"}, "role_id": {"100000001"}},
+			"1750000000,123456,68601469e53ddb1b85b5a36656952e92",
+		},
+		{
+			dsSaltCNBody, 1750000000, 150000, `{"is_high":true}`,
+			nil,
+			"1750000000,150000,af2c7a65f9331eadaf1cb63eb1e5c894",
+		},
+		{
+			dsSaltCN, 1700000000, 100001, "",
+			url.Values{"c": {"x y"}, "b": {"2", "3"}, "a": {"1"}},
+			"1700000000,100001,43108cef7d487eef08b38c466bd1f9e6",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := ds2(tt.salt, tt.t, tt.random, tt.body, tt.query); got != tt.want {
+			t.Errorf("ds2(%v) = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateDS2Random(t *testing.T) {
+	for range 100 {
+		parts := strings.Split(generateDS2(dsSaltCN, "", nil), ",")
+		if len(parts) != 3 {
+			t.Fatalf("malformed DS %v", parts)
+		}
+		r, err := strconv.Atoi(parts[1])
+		if err != nil || r < 100001 || r > 200000 {
+			t.Fatalf("random part %s out of range", parts[1])
+		}
+	}
+}
+
+func TestRegion(t *testing.T) {
+	tests := []struct {
+		config GameConfig
+		want   Region
+	}{
+		{GameConfig{server: "os_usa"}, RegionOverseas},
+		{GameConfig{server: "cn_gf01"}, RegionChina},
+		{GameConfig{server: "cn_qd01"}, RegionChina},
+		{GameConfig{server: "prod_gf_cn"}, RegionChina},
+		{GameConfig{server: "os_asia", region: RegionChina}, RegionChina},
+	}
+
+	for _, tt := range tests {
+		if got := tt.config.Region(); got != tt.want {
+			t.Errorf("%+v Region() = %s, want %s", tt.config, got, tt.want)
+		}
+	}
+}
+
+func TestBuildRequestChina(t *testing.T) {
+	withTestAccount(t)
+	tests := []struct {
+		config GameConfig
+		url    string
+	}{
+		{
+			GameConfig{game: GENSHIN, uid: "100000001", server: "cn_gf01"},
+			"https://api-takumi-record.mihoyo.com/game_record/app/genshin/api/dailyNote?role_id=100000001&server=cn_gf01",
+		},
+		{
+			GameConfig{game: STARRAIL, uid: "100000002", server: "prod_gf_cn"},
+			"https://api-takumi-record.mihoyo.com/game_record/app/hkrpg/api/note?role_id=100000002&server=prod_gf_cn",
+		},
+		{
+			GameConfig{game: ZZZ, uid: "10000003", server: "prod_gf_cn"},
+			"https://api-takumi-record.mihoyo.com/event/game_record_zzz/api/zzz/note?role_id=10000003&server=prod_gf_cn",
+		},
+	}
+
+	for _, tt := range tests {
+		req, err := buildRequest(t.Context(), tt.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.URL.String() != tt.url {
+			t.Errorf("url = %s, want %s", req.URL, tt.url)
+		}
+
+		parts := strings.Split(req.Header.Get("DS"), ",")
+		if len(parts) != 3 {
+			t.Fatalf("malformed DS %q", req.Header.Get("DS"))
+		}
+		ts, _ := strconv.ParseInt(parts[0], 10, 64)
+		r, _ := strconv.Atoi(parts[1])
+		if want := ds2(dsSaltCN, ts, r, "", req.URL.Query()); req.Header.Get("DS") != want {
+			t.Errorf("DS = %s, want %s", req.Header.Get("DS"), want)
+		}
+	}
+}
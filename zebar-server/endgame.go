@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type EndgameMode struct {
+	Id       string `json:"id"`
+	Game     GameId `json:"game"`
+	Name     string `json:"name"`
+	Stars    int    `json:"stars"`
+	MaxStars int    `json:"maxStars"`
+	EndsAt   int64  `json:"endsAt"`
+	ResetsIn int64  `json:"resetsIn"`
+}
+
+// recordDate is how HSR and ZZZ report schedule boundaries, in server time
+type recordDate struct {
+	Year   int `json:"year"`
+	Month  int `json:"month"`
+	Day    int `json:"day"`
+	Hour   int `json:"hour"`
+	Minute int `json:"minute"`
+	Second int `json:"second"`
+}
+
+func (d recordDate) Time(loc *time.Location) time.Time {
+	if d.Year == 0 {
+		return time.Time{}
+	}
+	return time.Date(d.Year, time.Month(d.Month), d.Day, d.Hour, d.Minute, d.Second, 0, loc)
+}
+
+func unixString(s string) time.Time {
+	ts, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || ts == 0 {
+		return time.Time{}
+	}
+	return time.Unix(ts, 0)
+}
+
+type endgameDefinition struct {
+	id       string
+	name     string
+	path     string
+	query    url.Values
+	maxStars int
+	parse    func(data json.RawMessage, loc *time.Location) (stars, maxStars int, endsAt time.Time, err error)
+}
+
+func parseSpiralAbyss(data json.RawMessage, loc *time.Location) (int, int, time.Time, error) {
+	var r struct {
+		TotalStar int    `json:"total_star"`
+		EndTime   string `json:"end_time"`
+	}
+	err := json.Unmarshal(data, &r)
+	return r.TotalStar, 0, unixString(r.EndTime), err
+}
+
+func parseImaginariumTheater(data json.RawMessage, loc *time.Location) (int, int, time.Time, error) {
+	var r struct {
+		Data []struct {
+			Stat struct {
+				MedalNum          int   `json:"medal_num"`
+				GetMedalRoundList []int `json:"get_medal_round_list"`
+			} `json:"stat"`
+			Schedule struct {
+				EndTime string `json:"end_time"`
+			} `json:"schedule"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &r); err != nil || len(r.Data) == 0 {
+		return 0, 0, time.Time{}, err
+	}
+	current := r.Data[0]
+	return current.Stat.MedalNum, len(current.Stat.GetMedalRoundList), unixString(current.Schedule.EndTime), nil
+}
+
+func parseStarRailChallenge(data json.RawMessage, loc *time.Location) (int, int, time.Time, error) {
+	var r struct {
+		StarNum int        `json:"star_num"`
+		EndTime recordDate `json:"end_time"`
+		Groups  []struct {
+			EndTime recordDate `json:"end_time"`
+		} `json:"groups"`
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return 0, 0, time.Time{}, err
+	}
+
+	end := r.EndTime
+	if end.Year == 0 && len(r.Groups) > 0 {
+		end = r.Groups[0].EndTime
+	}
+	return r.StarNum, 0, end.Time(loc), nil
+}
+
+var shiyuRatingStars = map[string]int{"S": 3, "A": 2, "B": 1}
+
+func parseShiyuDefense(data json.RawMessage, loc *time.Location) (int, int, time.Time, error) {
+	var r struct {
+		EndTime    string `json:"end_time"`
+		RatingList []struct {
+			Times  int    `json:"times"`
+			Rating string `json:"rating"`
+		} `json:"rating_list"`
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return 0, 0, time.Time{}, err
+	}
+
+	stars := 0
+	for _, rating := range r.RatingList {
+		stars += shiyuRatingStars[rating.Rating] * rating.Times
+	}
+	return stars, 0, unixString(r.EndTime), nil
+}
+
+func parseDeadlyAssault(data json.RawMessage, loc *time.Location) (int, int, time.Time, error) {
+	var r struct {
+		TotalStar int        `json:"total_star"`
+		EndTime   recordDate `json:"end_time"`
+	}
+	err := json.Unmarshal(data, &r)
+	return r.TotalStar, 0, r.EndTime.Time(loc), err
+}
+
+func endgameQuery(config GameConfig, def endgameDefinition) url.Values {
+	query := url.Values{}
+	for k, v := range def.query {
+		query[k] = v
+	}
+	// Deadly Assault is the odd one out with uid/region params
+	if def.path == "mem_detail" {
+		query.Set("uid", config.uid)
+		query.Set("region", config.server)
+	} else {
+		query.Set("role_id", config.uid)
+		query.Set("server", config.server)
+	}
+	return query
+}
+
+func EndgameStatus(ctx context.Context, config GameConfig) ([]EndgameMode, error) {
+	now := time.Now()
+	loc := ServerLocation(config.server)
+
+	var defs []endgameDefinition
+	if game, ok := GameById(config.game); ok {
+		defs = game.EndgameModes()
+	}
+
+	modes := []EndgameMode{}
+	for _, def := range defs {
+		var data json.RawMessage
+		if err := fetchRecord(ctx, config, def.path, endgameQuery(config, def), &data); err != nil {
+			return nil, err
+		}
+
+		stars, maxStars, endsAt, err := def.parse(data, loc)
+		if err != nil {
+			return nil, err
+		}
+		if maxStars == 0 {
+			maxStars = def.maxStars
+		}
+
+		mode := EndgameMode{
+			Id:       string(config.game) + "." + def.id,
+			Game:     config.game,
+			Name:     def.name,
+			Stars:    stars,
+			MaxStars: maxStars,
+		}
+		if !endsAt.IsZero() {
+			mode.EndsAt = endsAt.Unix()
+			mode.ResetsIn = max(int64(endsAt.Sub(now).Seconds()), 0)
+		}
+		modes = append(modes, mode)
+	}
+
+	return modes, nil
+}
+
+type EndgamePayload struct {
+	Game  GameId        `json:"game"`
+	Modes []EndgameMode `json:"modes"`
+}
+
+func (u *ResinUpdater) RunEndgameUpdates(ctx context.Context, config GameConfig) error {
+	modes, err := EndgameStatus(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	u.endgame[config.game] = modes
+	u.mu.Unlock()
+
+	u.publish(EndgameTopic(config.game), EndgamePayload{Game: config.game, Modes: modes})
+	return nil
+}
+
+type EndgameResponse struct {
+	Modes  []EndgameMode     `json:"modes"`
+	Errors map[GameId]string `json:"errors,omitempty"`
+}
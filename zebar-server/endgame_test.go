@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serves testdata/endgame/<game>_<endpoint>.json for record endpoints
+func withFakeRecords(t *testing.T) {
+	t.Helper()
+	withTestAccount(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("DS") == "" || r.Header.Get("Cookie") == "" {
+			http.Error(w, "unsigned request", http.StatusForbidden)
+			return
+		}
+		game := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+		b, err := os.ReadFile("testdata/endgame/" + game + "_" + path.Base(r.URL.Path) + ".json")
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	}))
+	t.Cleanup(srv.Close)
+
+	oldRecord, oldZZZ := recordURL, zzzRecordURL
+	recordURL, zzzRecordURL = srv.URL, srv.URL+"/zzz"
+	t.Cleanup(func() { recordURL, zzzRecordURL = oldRecord, oldZZZ })
+}
+
+func findMode(t *testing.T, modes []EndgameMode, id string) EndgameMode {
+	t.Helper()
+
+	for _, mode := range modes {
+		if mode.Id == id {
+			return mode
+		}
+	}
+	t.Fatalf("mode %s not found in %v", id, modes)
+	return EndgameMode{}
+}
+
+func TestEndgameStatus(t *testing.T) {
+	withFakeRecords(t)
+
+	tests := []struct {
+		config   GameConfig
+		id       string
+		stars    int
+		maxStars int
+		endsAt   string
+	}{
+		{GenshinConfig, "genshin.spiral_abyss", 33, 36, "2025-07-14T23:00:00Z"},
+		{GenshinConfig, "genshin.imaginarium_theater", 8, 10, "2025-06-30T20:00:00Z"},
+		{StarRailConfig, "hkrpg.memory_of_chaos", 30, 36, "2025-07-21T08:59:00Z"},
+		{StarRailConfig, "hkrpg.pure_fiction", 12, 12, "2025-07-14T08:59:00Z"},
+		{StarRailConfig, "hkrpg.apocalyptic_shadow", 0, 12, "2025-08-04T08:59:00Z"},
+		{ZZZConfig, "zzz.shiyu_defense", 17, 21, "2025-07-04T06:59:59Z"},
+		{ZZZConfig, "zzz.deadly_assault", 7, 9, "2025-06-27T08:59:59Z"},
+	}
+
+	for _, tt := range tests {
+		modes, err := EndgameStatus(t.Context(), tt.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mode := findMode(t, modes, tt.id)
+		endsAt, _ := time.Parse(time.RFC3339, tt.endsAt)
+		if mode.Stars != tt.stars || mode.MaxStars != tt.maxStars || mode.EndsAt != endsAt.Unix() {
+			t.Errorf("%s = %+v, want stars %d/%d ending %s", tt.id, mode, tt.stars, tt.maxStars, tt.endsAt)
+		}
+		if mode.ResetsIn != 0 {
+			t.Errorf("%s ended in the past but ResetsIn = %d", tt.id, mode.ResetsIn)
+		}
+	}
+}
+
+func TestEndgameStatusRetcode(t *testing.T) {
+	withTestAccount(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"retcode":10001,"message":"Please login","data":null}`))
+	}))
+	defer srv.Close()
+
+	old := recordURL
+	recordURL = srv.URL
+	defer func() { recordURL = old }()
+
+	if _, err := EndgameStatus(t.Context(), GenshinConfig); err == nil {
+		t.Error("expected error for non-zero retcode")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"strings"
+	"sync"
+	"time"
+)
+
+var cacheTTL = flag.Duration("cache-ttl", 30*time.Second, "how long a fetched game record is reused")
+
+// recordGroup collapses identical game record fetches. Callers asking for a
+// record that is already being fetched wait for that request instead of
+// sending their own, and a successful response is reused for ttl.
+type recordGroup struct {
+	ttl time.Duration
+
+	mu    sync.Mutex
+	calls map[string]*recordCall
+	cache map[string]cachedRecord
+}
+
+type recordCall struct {
+	done    chan struct{}
+	data    []byte
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+type cachedRecord struct {
+	data    []byte
+	fetched time.Time
+}
+
+func newRecordGroup(ttl time.Duration) *recordGroup {
+	return &recordGroup{
+		ttl:   ttl,
+		calls: make(map[string]*recordCall),
+		cache: make(map[string]cachedRecord),
+	}
+}
+
+var records = newRecordGroup(0)
+
+func (g *recordGroup) Do(ctx context.Context, key string, fetch func(context.Context) ([]byte, error)) ([]byte, error) {
+	g.mu.Lock()
+
+	if cached, ok := g.cache[key]; ok && time.Since(cached.fetched) < g.ttl {
+		g.mu.Unlock()
+		hoyoMetrics.Add("cache_hits", 1)
+		return cached.data, nil
+	}
+
+	c, ok := g.calls[key]
+	if ok {
+		hoyoMetrics.Add("deduplicated", 1)
+	} else {
+		// the fetch belongs to every waiter, so it is only cancelled once
+		// all of them have gone
+		fetchCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &recordCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = c
+		go g.run(fetchCtx, key, c, fetch)
+	}
+	c.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.data, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			// a caller arriving now gets a fresh fetch rather than
+			// joining one that is being torn down
+			c.cancel()
+			g.forget(key, c)
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (g *recordGroup) run(ctx context.Context, key string, c *recordCall, fetch func(context.Context) ([]byte, error)) {
+	defer c.cancel()
+
+	c.data, c.err = fetch(ctx)
+
+	g.mu.Lock()
+	// an invalidated fetch answers its waiters but is not cached
+	current := g.calls[key] == c
+	g.forget(key, c)
+	if c.err == nil && g.ttl > 0 && current {
+		now := time.Now()
+		for k, cached := range g.cache {
+			if now.Sub(cached.fetched) >= g.ttl {
+				delete(g.cache, k)
+			}
+		}
+		g.cache[key] = cachedRecord{data: c.data, fetched: now}
+	}
+	g.mu.Unlock()
+
+	close(c.done)
+}
+
+// Invalidate drops the cached records whose key starts with prefix, so the
+// next caller fetches them again. Fetches already under way still answer
+// whoever is waiting on them, but new callers do not join them.
+func (g *recordGroup) Invalidate(prefix string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for key := range g.cache {
+		if strings.HasPrefix(key, prefix) {
+			delete(g.cache, key)
+		}
+	}
+	for key := range g.calls {
+		if strings.HasPrefix(key, prefix) {
+			delete(g.calls, key)
+		}
+	}
+}
+
+func (g *recordGroup) forget(key string, c *recordCall) {
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+}
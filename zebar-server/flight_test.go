@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func withRecordGroup(t *testing.T, g *recordGroup) {
+	t.Helper()
+
+	old := records
+	records = g
+	t.Cleanup(func() { records = old })
+}
+
+func metric(name string) int64 {
+	v := hoyoMetrics.Get(name)
+	if v == nil {
+		return 0
+	}
+	n, _ := strconv.ParseInt(v.String(), 10, 64)
+	return n
+}
+
+// withCountingRecords serves the genshin note fixture after delay and counts
+// the requests that reach it.
+func withCountingRecords(t *testing.T, delay time.Duration) *atomic.Int32 {
+	t.Helper()
+	withTestAccount(t)
+
+	b, err := os.ReadFile("testdata/genshin_note.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(delay)
+		w.Write(b)
+	}))
+	t.Cleanup(srv.Close)
+
+	old := recordURL
+	recordURL = srv.URL
+	t.Cleanup(func() { recordURL = old })
+
+	return &calls
+}
+
+func TestConcurrentFetchesAreDeduplicated(t *testing.T) {
+	calls := withCountingRecords(t, 50*time.Millisecond)
+	withRecordGroup(t, newRecordGroup(0))
+	withCaptchaGate(t)
+
+	dedupedBefore := metric("deduplicated")
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := DailyNote(t.Context(), GenshinConfig); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d requests reached HoYoLAB, want 1", n)
+	}
+	if n := metric("deduplicated") - dedupedBefore; n != 9 {
+		t.Errorf("deduplicated = %d, want 9", n)
+	}
+
+	// nothing is cached with a zero ttl
+	DailyNote(t.Context(), GenshinConfig)
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d requests reached HoYoLAB, want 2", n)
+	}
+}
+
+func TestFetchesAreCached(t *testing.T) {
+	calls := withCountingRecords(t, 0)
+	g := newRecordGroup(time.Minute)
+	withRecordGroup(t, g)
+	withCaptchaGate(t)
+
+	hitsBefore := metric("cache_hits")
+
+	for range 3 {
+		if _, err := DailyNote(t.Context(), GenshinConfig); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d requests reached HoYoLAB, want 1", n)
+	}
+	if n := metric("cache_hits") - hitsBefore; n != 2 {
+		t.Errorf("cache hits = %d, want 2", n)
+	}
+
+	// other accounts and endpoints are cached separately
+	other := GenshinConfig
+	other.uid = "700000001"
+	DailyNote(t.Context(), other)
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d requests reached HoYoLAB, want 2", n)
+	}
+
+	g.ttl = 0
+	DailyNote(t.Context(), GenshinConfig)
+	if n := calls.Load(); n != 3 {
+		t.Errorf("%d requests reached HoYoLAB after expiry, want 3", n)
+	}
+}
+
+func TestFailedFetchesAreNotCached(t *testing.T) {
+	g := newRecordGroup(time.Minute)
+	fails := true
+	key := "genshin/1/note"
+
+	fetch := func(context.Context) ([]byte, error) {
+		if fails {
+			return nil, context.DeadlineExceeded
+		}
+		return []byte("ok"), nil
+	}
+
+	if _, err := g.Do(t.Context(), key, fetch); err == nil {
+		t.Fatal("expected the failure to be returned")
+	}
+	fails = false
+	if b, err := g.Do(t.Context(), key, fetch); err != nil || string(b) != "ok" {
+		t.Errorf("got %q, %v", b, err)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	g := newRecordGroup(time.Minute)
+	key, other := "genshin/1/os_usa/dailyNote", "genshin/2/os_usa/dailyNote"
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fetch := func(context.Context) ([]byte, error) {
+		if calls.Add(1) == 1 {
+			<-release
+			return []byte("stale"), nil
+		}
+		return []byte("fresh"), nil
+	}
+
+	// a fetch started before the invalidation is neither joined nor cached
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.Do(t.Context(), key, fetch)
+	}()
+	waitFor(t, "the first fetch", func() bool { return calls.Load() == 1 })
+	g.Invalidate("genshin/1/os_usa/")
+	if b, _ := g.Do(t.Context(), key, fetch); string(b) != "fresh" {
+		t.Errorf("after invalidating got %q", b)
+	}
+	close(release)
+	<-done
+	if b, _ := g.Do(t.Context(), key, fetch); string(b) != "fresh" {
+		t.Errorf("the invalidated fetch was cached, got %q", b)
+	}
+
+	g.Do(t.Context(), other, fetch)
+	before := calls.Load()
+	g.Invalidate("genshin/1/os_usa/")
+	g.Do(t.Context(), key, fetch)
+	g.Do(t.Context(), other, fetch)
+	if n := calls.Load() - before; n != 1 {
+		t.Errorf("%d fetches, want 1 for the invalidated account only", n)
+	}
+}
+
+func TestSharedFetchSurvivesOneCallerLeaving(t *testing.T) {
+	g := newRecordGroup(0)
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	fetch := func(ctx context.Context) ([]byte, error) {
+		close(started)
+		select {
+		case <-release:
+			return []byte("note"), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	leaving, leave := context.WithCancel(t.Context())
+	first := make(chan error, 1)
+	go func() {
+		_, err := g.Do(leaving, "key", fetch)
+		first <- err
+	}()
+	<-started
+
+	second := make(chan []byte, 1)
+	go func() {
+		b, _ := g.Do(t.Context(), "key", fetch)
+		second <- b
+	}()
+	for {
+		g.mu.Lock()
+		waiters := g.calls["key"].waiters
+		g.mu.Unlock()
+		if waiters == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	leave()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("leaving caller got %v", err)
+	}
+
+	close(release)
+	if b := <-second; string(b) != "note" {
+		t.Errorf("remaining caller got %q", b)
+	}
+}
+
+func TestClientIsThrottled(t *testing.T) {
+	calls := withCountingRecords(t, 0)
+	c := newHoyoClient(time.Second)
+	c.limiter = newTokenBucket(50, 1)
+	withHoyoClient(t, c)
+	withRecordGroup(t, newRecordGroup(0))
+	withCaptchaGate(t)
+
+	throttledBefore := metric("throttled")
+
+	for range 3 {
+		if _, err := DailyNote(t.Context(), GenshinConfig); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := calls.Load(); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+	if n := metric("throttled") - throttledBefore; n != 2 {
+		t.Errorf("throttled = %d, want 2", n)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Game is everything zbserv needs to know to track one title. Each game
+// lives in its own game_*.go file and registers itself from init.
+type Game interface {
+	Id() GameId
+	Processes() []string
+	// Recharge is how long one point of stamina takes to recover.
+	Recharge() time.Duration
+	RecordURL(region Region, path string) string
+	SetHeaders(h http.Header, config GameConfig)
+	NotePath() string
+	DecodeNote(data []byte) (Note, error)
+
+	// EnvPrefix starts the account's settings in conf.env, as in
+	// GENSHIN_UID.
+	EnvPrefix() string
+	// Account is the game's account, holding the default server and version
+	// until conf.env overrides them. Without a uid it is not fetched.
+	Account() *GameConfig
+	// Servers maps each of the game's servers to its offset from UTC in
+	// hours. Servers do not observe daylight saving time.
+	Servers() map[string]int
+	CheckInEndpoint() checkInEndpoint
+	// RedeemEndpoint reports false for games without web code redemption.
+	RedeemEndpoint() (redeemEndpoint, bool)
+	EndgameModes() []endgameDefinition
+}
+
+type Note interface {
+	Stamina() (current, max int)
+	Todos(now time.Time) []TodoItem
+}
+
+// RecoveringNote is implemented by notes that report how long until stamina
+// is full, which lets the updater tick in step with the game.
+type RecoveringNote interface {
+	RecoverySeconds() int
+}
+
+var registry = struct {
+	mu    sync.RWMutex
+	games []Game
+}{}
+
+func RegisterGame(game Game) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	for _, g := range registry.games {
+		if g.Id() == game.Id() {
+			panic(fmt.Sprintf("game %s registered twice", game.Id()))
+		}
+	}
+	registry.games = append(registry.games, game)
+}
+
+func Games() []Game {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	return append([]Game(nil), registry.games...)
+}
+
+func GameById(id GameId) (Game, bool) {
+	for _, g := range Games() {
+		if g.Id() == id {
+			return g, true
+		}
+	}
+	return nil, false
+}
+
+func GameByProcess(name string) (Game, bool) {
+	for _, g := range Games() {
+		for _, p := range g.Processes() {
+			if p == name {
+				return g, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func GameProcesses() []string {
+	var names []string
+	for _, g := range Games() {
+		names = append(names, g.Processes()...)
+	}
+	return names
+}
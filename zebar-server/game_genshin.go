@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	GenshinProcess = "GenshinImpact.exe"
+
+	uidGenshin     = "604392290"
+	serverGenshin  = "os_usa"
+	versionGenshin = "2.11.1"
+)
+
+var GenshinConfig = GameConfig{
+	game:    GENSHIN,
+	uid:     uidGenshin,
+	server:  serverGenshin,
+	version: versionGenshin,
+}
+
+var genshinServers = map[string]int{
+	"os_usa":  -5,
+	"os_euro": 1,
+	"os_asia": 8,
+	"os_cht":  8,
+	"cn_gf01": 8,
+	"cn_qd01": 8,
+}
+
+var genshinEndgame = []endgameDefinition{
+	{
+		id:       "spiral_abyss",
+		name:     "Spiral Abyss",
+		path:     "spiralAbyss",
+		query:    url.Values{"schedule_type": {"1"}},
+		maxStars: 36,
+		parse:    parseSpiralAbyss,
+	},
+	{
+		id:       "imaginarium_theater",
+		name:     "Imaginarium Theater",
+		path:     "role_combat",
+		query:    url.Values{"need_detail": {"false"}},
+		maxStars: 10,
+		parse:    parseImaginariumTheater,
+	},
+}
+
+type genshinGame struct{}
+
+func init() {
+	RegisterGame(genshinGame{})
+}
+
+func (genshinGame) Id() GameId              { return GENSHIN }
+func (genshinGame) Processes() []string     { return []string{GenshinProcess} }
+func (genshinGame) Recharge() time.Duration { return time.Second * 480 }
+func (genshinGame) NotePath() string        { return "dailyNote" }
+func (genshinGame) EnvPrefix() string       { return "GENSHIN" }
+func (genshinGame) Account() *GameConfig    { return &GenshinConfig }
+func (genshinGame) Servers() map[string]int { return genshinServers }
+
+func (genshinGame) CheckInEndpoint() checkInEndpoint {
+	return checkInEndpoint{url: "https://sg-hk4e-api.hoyolab.com/event/sol", actId: "e202102251931481"}
+}
+
+func (genshinGame) RedeemEndpoint() (redeemEndpoint, bool) {
+	return redeemEndpoint{url: "https://sg-hk4e-api.hoyoverse.com/common/apicdkey/api/webExchangeCdkey", gameBiz: "hk4e_global"}, true
+}
+
+func (genshinGame) EndgameModes() []endgameDefinition { return genshinEndgame }
+
+func (genshinGame) RecordURL(region Region, path string) string {
+	if region == RegionChina {
+		return cnRecordURL + "/genshin/api/" + path
+	}
+	return recordURL + "/genshin/api/" + path
+}
+
+func (genshinGame) SetHeaders(h http.Header, config GameConfig) {
+	h.Set("x-rpc-app_version", config.version)
+}
+
+func (genshinGame) DecodeNote(data []byte) (Note, error) {
+	var result DailyNoteResponseGenshin
+	err := json.Unmarshal(data, &result)
+	return &result, err
+}
+
+type DailyNoteResponseGenshin struct {
+	Retcode int    `json:"retcode"`
+	Message string `json:"message"`
+	Data    struct {
+		CurrentResin              int    `json:"current_resin"`
+		MaxResin                  int    `json:"max_resin"`
+		ResinRecoveryTime         string `json:"resin_recovery_time"`
+		FinishedTaskNum           int    `json:"finished_task_num"`
+		TotalTaskNum              int    `json:"total_task_num"`
+		IsExtraTaskRewardReceived bool   `json:"is_extra_task_reward_received"`
+		RemainResinDiscountNum    int    `json:"remain_resin_discount_num"`
+		ResinDiscountNumLimit     int    `json:"resin_discount_num_limit"`
+		CurrentExpeditionNum      int    `json:"current_expedition_num"`
+		MaxExpeditionNum          int    `json:"max_expedition_num"`
+		Expeditions               []struct {
+			AvatarSideIcon string `json:"avatar_side_icon"`
+			Status         string `json:"status"`
+			RemainedTime   string `json:"remained_time"`
+		} `json:"expeditions"`
+		CurrentHomeCoin      int    `json:"current_home_coin"`
+		MaxHomeCoin          int    `json:"max_home_coin"`
+		HomeCoinRecoveryTime string `json:"home_coin_recovery_time"`
+		CalendarURL          string `json:"calendar_url"`
+		Transformer          struct {
+			Obtained     bool `json:"obtained"`
+			RecoveryTime struct {
+				Day     int  `json:"Day"`
+				Hour    int  `json:"Hour"`
+				Minute  int  `json:"Minute"`
+				Second  int  `json:"Second"`
+				Reached bool `json:"reached"`
+			} `json:"recovery_time"`
+			Wiki        string `json:"wiki"`
+			Noticed     bool   `json:"noticed"`
+			LatestJobID string `json:"latest_job_id"`
+		} `json:"transformer"`
+		DailyTask struct {
+			TotalNum                  int  `json:"total_num"`
+			FinishedNum               int  `json:"finished_num"`
+			IsExtraTaskRewardReceived bool `json:"is_extra_task_reward_received"`
+			TaskRewards               []struct {
+				Status string `json:"status"`
+			} `json:"task_rewards"`
+			AttendanceRewards []struct {
+				Status   string `json:"status"`
+				Progress int    `json:"progress"`
+			} `json:"attendance_rewards"`
+			AttendanceVisible                bool   `json:"attendance_visible"`
+			StoredAttendance                 string `json:"stored_attendance"`
+			StoredAttendanceRefreshCountdown int    `json:"stored_attendance_refresh_countdown"`
+		} `json:"daily_task"`
+		ArchonQuestProgress struct {
+			List                    []interface{} `json:"list"`
+			IsOpenArchonQuest       bool          `json:"is_open_archon_quest"`
+			IsFinishAllMainline     bool          `json:"is_finish_all_mainline"`
+			IsFinishAllInterchapter bool          `json:"is_finish_all_interchapter"`
+			WikiURL                 string        `json:"wiki_url"`
+		} `json:"archon_quest_progress"`
+	} `json:"data"`
+}
+
+func (r *DailyNoteResponseGenshin) Stamina() (int, int) {
+	return r.Data.CurrentResin, r.Data.MaxResin
+}
+
+func (r *DailyNoteResponseGenshin) RecoverySeconds() int {
+	seconds, _ := strconv.Atoi(r.Data.ResinRecoveryTime)
+	return seconds
+}
+
+func (r *DailyNoteResponseGenshin) Todos(now time.Time) []TodoItem {
+	d := r.Data
+
+	finished, total := d.DailyTask.FinishedNum, d.DailyTask.TotalNum
+	if total == 0 {
+		finished, total = d.FinishedTaskNum, d.TotalTaskNum
+	}
+
+	return []TodoItem{
+		newTodo(GENSHIN, "commissions", "Daily Commissions", finished, total, ResetDaily),
+		newTodo(GENSHIN, "commission_reward", "Commission Reward", boolCount(d.IsExtraTaskRewardReceived), 1, ResetDaily),
+		newTodo(GENSHIN, "weekly_bosses", "Weekly Bosses", d.ResinDiscountNumLimit-d.RemainResinDiscountNum, d.ResinDiscountNumLimit, ResetWeekly),
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const (
+	Honkai3Process = "BH3.exe"
+
+	serverHonkai3  = "usa01"
+	versionHonkai3 = "2.11.1"
+)
+
+// HI3 is opt-in, the uid comes from HI3_UID in conf.env
+var Honkai3Config = GameConfig{
+	game:    HONKAI3,
+	server:  serverHonkai3,
+	version: versionHonkai3,
+}
+
+var honkai3Servers = map[string]int{
+	"usa01":      -5,
+	"eur01":      1,
+	"overseas01": 8,
+	"asia01":     8,
+	"jp01":       9,
+	"kr01":       9,
+}
+
+type honkai3Game struct{}
+
+func init() {
+	RegisterGame(honkai3Game{})
+}
+
+func (honkai3Game) Id() GameId              { return HONKAI3 }
+func (honkai3Game) Processes() []string     { return []string{Honkai3Process} }
+func (honkai3Game) Recharge() time.Duration { return time.Minute * 6 }
+func (honkai3Game) NotePath() string        { return "note" }
+func (honkai3Game) EnvPrefix() string       { return "HI3" }
+func (honkai3Game) Account() *GameConfig    { return &Honkai3Config }
+func (honkai3Game) Servers() map[string]int { return honkai3Servers }
+
+func (honkai3Game) CheckInEndpoint() checkInEndpoint {
+	return checkInEndpoint{url: "https://sg-public-api.hoyolab.com/event/mani", actId: "e202110291205111"}
+}
+
+func (honkai3Game) RedeemEndpoint() (redeemEndpoint, bool) { return redeemEndpoint{}, false }
+func (honkai3Game) EndgameModes() []endgameDefinition      { return nil }
+
+func (honkai3Game) RecordURL(region Region, path string) string {
+	if region == RegionChina {
+		return cnRecordURL + "/honkai3rd/api/" + path
+	}
+	return recordURL + "/honkai3rd/api/" + path
+}
+
+func (honkai3Game) SetHeaders(h http.Header, config GameConfig) {
+	h.Set("x-rpc-app_version", config.version)
+}
+
+func (honkai3Game) DecodeNote(data []byte) (Note, error) {
+	var result DailyNoteResponseHonkai3
+	err := json.Unmarshal(data, &result)
+	return &result, err
+}
+
+type DailyNoteResponseHonkai3 struct {
+	Retcode int    `json:"retcode"`
+	Message string `json:"message"`
+	Data    struct {
+		CurrentStamina     int `json:"current_stamina"`
+		MaxStamina         int `json:"max_stamina"`
+		StaminaRecoverTime int `json:"stamina_recover_time"`
+		CurrentTrainScore  int `json:"current_train_score"`
+		MaxTrainScore      int `json:"max_train_score"`
+		GreedyEndless      struct {
+			CurReward   int `json:"cur_reward"`
+			MaxReward   int `json:"max_reward"`
+			ScheduleEnd int `json:"schedule_end"`
+		} `json:"greedy_endless"`
+		UltraEndless struct {
+			GroupLevel     int `json:"group_level"`
+			ChallengeScore int `json:"challenge_score"`
+			ScheduleEnd    int `json:"schedule_end"`
+		} `json:"ultra_endless"`
+		BattleField struct {
+			CurReward    int `json:"cur_reward"`
+			MaxReward    int `json:"max_reward"`
+			CurSssReward int `json:"cur_sss_reward"`
+			MaxSssReward int `json:"max_sss_reward"`
+			ScheduleEnd  int `json:"schedule_end"`
+		} `json:"battle_field"`
+		GodWar struct {
+			CurReward   int `json:"cur_reward"`
+			MaxReward   int `json:"max_reward"`
+			ScheduleEnd int `json:"schedule_end"`
+		} `json:"god_war"`
+	} `json:"data"`
+}
+
+func (r *DailyNoteResponseHonkai3) Stamina() (int, int) {
+	return r.Data.CurrentStamina, r.Data.MaxStamina
+}
+
+func (r *DailyNoteResponseHonkai3) RecoverySeconds() int {
+	return r.Data.StaminaRecoverTime
+}
+
+func (r *DailyNoteResponseHonkai3) Todos(now time.Time) []TodoItem {
+	d := r.Data
+
+	arena := newTodo(HONKAI3, "memorial_arena", "Memorial Arena", d.BattleField.CurReward, d.BattleField.MaxReward, ResetWeekly)
+	arena.ResetsAt = int64(d.BattleField.ScheduleEnd)
+
+	elysian := newTodo(HONKAI3, "elysian_realm", "Elysian Realm", d.GodWar.CurReward, d.GodWar.MaxReward, ResetWeekly)
+	elysian.ResetsAt = int64(d.GodWar.ScheduleEnd)
+
+	manifold := newTodo(HONKAI3, "q_manifold", "Q-Manifold", d.GreedyEndless.CurReward, d.GreedyEndless.MaxReward, ResetWeekly)
+	manifold.ResetsAt = int64(d.GreedyEndless.ScheduleEnd)
+
+	return []TodoItem{
+		newTodo(HONKAI3, "daily_training", "Daily Training", d.CurrentTrainScore, d.MaxTrainScore, ResetDaily),
+		arena,
+		elysian,
+		manifold,
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	StarRailProcess = "StarRail.exe"
+
+	uidHSR     = "614963011"
+	serverHsr  = "prod_official_usa"
+	versionHsr = "2.50.1"
+)
+
+var StarRailConfig = GameConfig{
+	game:    STARRAIL,
+	uid:     uidHSR,
+	server:  serverHsr,
+	version: versionHsr,
+}
+
+var starRailServers = map[string]int{
+	"prod_official_usa":  -5,
+	"prod_official_eur":  1,
+	"prod_official_asia": 8,
+	"prod_official_cht":  8,
+	"prod_gf_cn":         8,
+	"prod_qd_cn":         8,
+}
+
+var starRailEndgame = []endgameDefinition{
+	{
+		id:       "memory_of_chaos",
+		name:     "Memory of Chaos",
+		path:     "challenge",
+		query:    url.Values{"schedule_type": {"1"}, "need_all": {"false"}},
+		maxStars: 36,
+		parse:    parseStarRailChallenge,
+	},
+	{
+		id:       "pure_fiction",
+		name:     "Pure Fiction",
+		path:     "challenge_story",
+		query:    url.Values{"schedule_type": {"1"}, "need_all": {"false"}, "type": {"story"}},
+		maxStars: 12,
+		parse:    parseStarRailChallenge,
+	},
+	{
+		id:       "apocalyptic_shadow",
+		name:     "Apocalyptic Shadow",
+		path:     "challenge_boss",
+		query:    url.Values{"schedule_type": {"1"}, "need_all": {"false"}, "type": {"boss"}},
+		maxStars: 12,
+		parse:    parseStarRailChallenge,
+	},
+}
+
+type starRailGame struct{}
+
+func init() {
+	RegisterGame(starRailGame{})
+}
+
+func (starRailGame) Id() GameId              { return STARRAIL }
+func (starRailGame) Processes() []string     { return []string{StarRailProcess} }
+func (starRailGame) Recharge() time.Duration { return time.Second * 360 }
+func (starRailGame) NotePath() string        { return "note" }
+func (starRailGame) EnvPrefix() string       { return "HSR" }
+func (starRailGame) Account() *GameConfig    { return &StarRailConfig }
+func (starRailGame) Servers() map[string]int { return starRailServers }
+
+func (starRailGame) CheckInEndpoint() checkInEndpoint {
+	return checkInEndpoint{url: "https://sg-public-api.hoyolab.com/event/luna/os", actId: "e202303301540311"}
+}
+
+func (starRailGame) RedeemEndpoint() (redeemEndpoint, bool) {
+	return redeemEndpoint{url: "https://sg-hkrpg-api.hoyoverse.com/common/apicdkey/api/webExchangeCdkey", gameBiz: "hkrpg_global"}, true
+}
+
+func (starRailGame) EndgameModes() []endgameDefinition { return starRailEndgame }
+
+func (starRailGame) RecordURL(region Region, path string) string {
+	if region == RegionChina {
+		return cnRecordURL + "/hkrpg/api/" + path
+	}
+	return recordURL + "/hkrpg/api/" + path
+}
+
+func (starRailGame) SetHeaders(h http.Header, config GameConfig) {
+	h.Set("x-rpc-app_version", config.version)
+}
+
+func (starRailGame) DecodeNote(data []byte) (Note, error) {
+	var result DailyNoteResponseStarRail
+	err := json.Unmarshal(data, &result)
+	return &result, err
+}
+
+type DailyNoteResponseStarRail struct {
+	Retcode int    `json:"retcode"`
+	Message string `json:"message"`
+	Data    struct {
+		CurrentStamina       int `json:"current_stamina"`
+		MaxStamina           int `json:"max_stamina"`
+		StaminaRecoverTime   int `json:"stamina_recover_time"`
+		StaminaFullTs        int `json:"stamina_full_ts"`
+		AcceptedEpeditionNum int `json:"accepted_epedition_num"`
+		TotalExpeditionNum   int `json:"total_expedition_num"`
+		Expeditions          []struct {
+			Avatars       []string `json:"avatars"`
+			Status        string   `json:"status"`
+			RemainingTime int      `json:"remaining_time"`
+			Name          string   `json:"name"`
+			ItemURL       string   `json:"item_url"`
+			FinishTs      int      `json:"finish_ts"`
+		} `json:"expeditions"`
+		CurrentTrainScore        int  `json:"current_train_score"`
+		MaxTrainScore            int  `json:"max_train_score"`
+		CurrentRogueScore        int  `json:"current_rogue_score"`
+		MaxRogueScore            int  `json:"max_rogue_score"`
+		WeeklyCocoonCnt          int  `json:"weekly_cocoon_cnt"`
+		WeeklyCocoonLimit        int  `json:"weekly_cocoon_limit"`
+		CurrentReserveStamina    int  `json:"current_reserve_stamina"`
+		IsReserveStaminaFull     bool `json:"is_reserve_stamina_full"`
+		RogueTournWeeklyUnlocked bool `json:"rogue_tourn_weekly_unlocked"`
+		RogueTournWeeklyMax      int  `json:"rogue_tourn_weekly_max"`
+		RogueTournWeeklyCur      int  `json:"rogue_tourn_weekly_cur"`
+		CurrentTs                int  `json:"current_ts"`
+		RogueTournExpIsFull      bool `json:"rogue_tourn_exp_is_full"`
+	} `json:"data"`
+}
+
+func (r *DailyNoteResponseStarRail) Stamina() (int, int) {
+	return r.Data.CurrentStamina, r.Data.MaxStamina
+}
+
+func (r *DailyNoteResponseStarRail) RecoverySeconds() int {
+	return r.Data.StaminaRecoverTime
+}
+
+func (r *DailyNoteResponseStarRail) Todos(now time.Time) []TodoItem {
+	d := r.Data
+
+	return []TodoItem{
+		newTodo(STARRAIL, "daily_training", "Daily Training", d.CurrentTrainScore, d.MaxTrainScore, ResetDaily),
+		newTodo(STARRAIL, "echo_of_war", "Echo of War", d.WeeklyCocoonLimit-d.WeeklyCocoonCnt, d.WeeklyCocoonLimit, ResetWeekly),
+		newTodo(STARRAIL, "simulated_universe", "Simulated Universe", d.CurrentRogueScore, d.MaxRogueScore, ResetWeekly),
+	}
+}
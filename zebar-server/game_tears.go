@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const (
+	TearsProcess = "TearsOfThemis.exe"
+
+	serverTears  = "glb_prod_wd01"
+	versionTears = "2.11.1"
+)
+
+// Tears of Themis is opt-in, the uid comes from TOT_UID in conf.env
+var TearsConfig = GameConfig{
+	game:    TEARS,
+	server:  serverTears,
+	version: versionTears,
+}
+
+// a single server for every region outside China
+var tearsServers = map[string]int{
+	"glb_prod_wd01": 8,
+}
+
+type tearsGame struct{}
+
+func init() {
+	RegisterGame(tearsGame{})
+}
+
+func (tearsGame) Id() GameId              { return TEARS }
+func (tearsGame) Processes() []string     { return []string{TearsProcess} }
+func (tearsGame) Recharge() time.Duration { return time.Minute * 6 }
+func (tearsGame) NotePath() string        { return "note" }
+func (tearsGame) EnvPrefix() string       { return "TOT" }
+func (tearsGame) Account() *GameConfig    { return &TearsConfig }
+func (tearsGame) Servers() map[string]int { return tearsServers }
+
+func (tearsGame) CheckInEndpoint() checkInEndpoint {
+	return checkInEndpoint{url: "https://sg-public-api.hoyolab.com/event/luna/os", actId: "e202202281857121"}
+}
+
+func (tearsGame) RedeemEndpoint() (redeemEndpoint, bool) { return redeemEndpoint{}, false }
+func (tearsGame) EndgameModes() []endgameDefinition      { return nil }
+
+func (tearsGame) RecordURL(region Region, path string) string {
+	if region == RegionChina {
+		return cnRecordURL + "/nxx/api/" + path
+	}
+	return recordURL + "/nxx/api/" + path
+}
+
+func (tearsGame) SetHeaders(h http.Header, config GameConfig) {
+	h.Set("x-rpc-app_version", config.version)
+}
+
+func (tearsGame) DecodeNote(data []byte) (Note, error) {
+	var result DailyNoteResponseTears
+	err := json.Unmarshal(data, &result)
+	return &result, err
+}
+
+type DailyNoteResponseTears struct {
+	Retcode int    `json:"retcode"`
+	Message string `json:"message"`
+	Data    struct {
+		CurrentStamina     int `json:"current_stamina"`
+		MaxStamina         int `json:"max_stamina"`
+		StaminaRecoverTime int `json:"stamina_recover_time"`
+	} `json:"data"`
+}
+
+func (r *DailyNoteResponseTears) Stamina() (int, int) {
+	return r.Data.CurrentStamina, r.Data.MaxStamina
+}
+
+func (r *DailyNoteResponseTears) RecoverySeconds() int {
+	return r.Data.StaminaRecoverTime
+}
+
+func (r *DailyNoteResponseTears) Todos(now time.Time) []TodoItem {
+	return []TodoItem{}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// withGames swaps the registry for games until the test ends.
+func withGames(t *testing.T, games ...Game) {
+	t.Helper()
+
+	registry.mu.Lock()
+	old := registry.games
+	registry.games = games
+	registry.mu.Unlock()
+	t.Cleanup(func() {
+		registry.mu.Lock()
+		registry.games = old
+		registry.mu.Unlock()
+	})
+}
+
+func TestRegisteredGames(t *testing.T) {
+	tests := []struct {
+		id      GameId
+		process string
+		fixture string
+		current int
+		max     int
+	}{
+		{GENSHIN, GenshinProcess, "genshin_note.json", 112, 200},
+		{STARRAIL, StarRailProcess, "hkrpg_note.json", 240, 240},
+		{ZZZ, ZZZProcess, "zzz_note.json", 187, 240},
+		{HONKAI3, Honkai3Process, "bh3_note.json", 96, 160},
+		{TEARS, TearsProcess, "nxx_note.json", 71, 128},
+	}
+
+	for _, tt := range tests {
+		game, ok := GameByProcess(tt.process)
+		if !ok || game.Id() != tt.id {
+			t.Errorf("GameByProcess(%s) = %v, %v", tt.process, game, ok)
+			continue
+		}
+
+		current, max := decodeFixture(t, tt.id, tt.fixture).Stamina()
+		if current != tt.current || max != tt.max {
+			t.Errorf("%s stamina = %d/%d, want %d/%d", tt.id, current, max, tt.current, tt.max)
+		}
+		if game.Recharge() <= 0 {
+			t.Errorf("%s has no recharge interval", tt.id)
+		}
+	}
+
+	if _, ok := GameByProcess("notepad.exe"); ok {
+		t.Error("unexpected game for notepad.exe")
+	}
+}
+
+func TestRecoverySeconds(t *testing.T) {
+	tests := []struct {
+		id      GameId
+		fixture string
+		want    int
+	}{
+		{GENSHIN, "genshin_note.json", 42240},
+		{STARRAIL, "hkrpg_note.json", 0},
+		{ZZZ, "zzz_note.json", 19080},
+		{HONKAI3, "bh3_note.json", 23040},
+		{TEARS, "nxx_note.json", 20340},
+	}
+
+	for _, tt := range tests {
+		note, ok := decodeFixture(t, tt.id, tt.fixture).(RecoveringNote)
+		if !ok {
+			t.Errorf("%s note does not report recovery time", tt.id)
+			continue
+		}
+		if got := note.RecoverySeconds(); got != tt.want {
+			t.Errorf("%s RecoverySeconds = %d, want %d", tt.id, got, tt.want)
+		}
+	}
+}
+
+// HI3 and Tears of Themis are only fetched once they have a uid
+func TestOptInGames(t *testing.T) {
+	for _, config := range []*GameConfig{&Honkai3Config, &TearsConfig} {
+		old := *config
+		t.Cleanup(func() { *config = old })
+
+		config.uid = ""
+		for _, c := range Configs() {
+			if c.game == config.game {
+				t.Errorf("%s configured without a uid", config.game)
+			}
+		}
+
+		config.uid = "10000001"
+		if len(accountsFor(config.game)) != 1 {
+			t.Errorf("%s missing from configs with a uid", config.game)
+		}
+	}
+}
+
+func TestRegisterGameTwicePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic on duplicate registration")
+		}
+	}()
+	RegisterGame(genshinGame{})
+}
+
+func TestProcessQuery(t *testing.T) {
+	query := processQuery(GameProcesses())
+
+	for _, name := range []string{GenshinProcess, StarRailProcess, ZZZProcess} {
+		if !strings.Contains(query, "TargetInstance.Name = '"+name+"'") {
+			t.Errorf("query is missing %s:\n%s", name, query)
+		}
+	}
+}
+
+func TestBuildRequest(t *testing.T) {
+	withTestAccount(t)
+	tests := []struct {
+		config GameConfig
+		url    string
+	}{
+		{GenshinConfig, "https://bbs-api-os.hoyolab.com/game_record/genshin/api/dailyNote?role_id=" + uidGenshin + "&server=os_usa"},
+		{StarRailConfig, "https://bbs-api-os.hoyolab.com/game_record/hkrpg/api/note?role_id=" + uidHSR + "&server=prod_official_usa"},
+		{ZZZConfig, "https://sg-public-api.hoyolab.com/event/game_record_zzz/api/zzz/note?role_id=1000482805&server=prod_gf_us"},
+		{
+			GameConfig{game: HONKAI3, uid: "10000001", server: serverHonkai3, version: versionHonkai3},
+			"https://bbs-api-os.hoyolab.com/game_record/honkai3rd/api/note?role_id=10000001&server=usa01",
+		},
+		{
+			GameConfig{game: TEARS, uid: "20000001", server: serverTears, version: versionTears},
+			"https://bbs-api-os.hoyolab.com/game_record/nxx/api/note?role_id=20000001&server=glb_prod_wd01",
+		},
+	}
+
+	for _, tt := range tests {
+		req, err := buildRequest(t.Context(), tt.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.URL.String() != tt.url {
+			t.Errorf("%s url = %s, want %s", tt.config.game, req.URL, tt.url)
+		}
+		if req.Header.Get("DS") == "" {
+			t.Errorf("%s request is missing DS", tt.config.game)
+		}
+		if version := req.Header.Get("x-rpc-app_version"); version != tt.config.version {
+			t.Errorf("%s app version = %q, want %q", tt.config.game, version, tt.config.version)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const ZZZProcess = "ZenlessZoneZero.exe"
+
+var ZZZConfig = GameConfig{
+	game:   ZZZ,
+	uid:    "1000482805",
+	server: "prod_gf_us",
+}
+
+var zzzServers = map[string]int{
+	"prod_gf_us": -5,
+	"prod_gf_eu": 1,
+	"prod_gf_jp": 8,
+	"prod_gf_sg": 8,
+	"prod_gf_cn": 8,
+}
+
+var zzzEndgame = []endgameDefinition{
+	{
+		id:       "shiyu_defense",
+		name:     "Shiyu Defense",
+		path:     "challenge",
+		query:    url.Values{"schedule_type": {"1"}},
+		maxStars: 21,
+		parse:    parseShiyuDefense,
+	},
+	{
+		id:       "deadly_assault",
+		name:     "Deadly Assault",
+		path:     "mem_detail",
+		query:    url.Values{"schedule_type": {"1"}},
+		maxStars: 9,
+		parse:    parseDeadlyAssault,
+	},
+}
+
+type zzzGame struct{}
+
+func init() {
+	RegisterGame(zzzGame{})
+}
+
+func (zzzGame) Id() GameId              { return ZZZ }
+func (zzzGame) Processes() []string     { return []string{ZZZProcess} }
+func (zzzGame) Recharge() time.Duration { return time.Minute * 6 }
+func (zzzGame) NotePath() string        { return "note" }
+func (zzzGame) EnvPrefix() string       { return "ZZZ" }
+func (zzzGame) Account() *GameConfig    { return &ZZZConfig }
+func (zzzGame) Servers() map[string]int { return zzzServers }
+
+func (zzzGame) CheckInEndpoint() checkInEndpoint {
+	return checkInEndpoint{url: "https://sg-public-api.hoyolab.com/event/luna/zzz/os", actId: "e202406031448091", signGame: "zzz"}
+}
+
+func (zzzGame) RedeemEndpoint() (redeemEndpoint, bool) {
+	return redeemEndpoint{url: "https://public-operation-nap.hoyoverse.com/common/apicdkey/api/webExchangeCdkey", gameBiz: "nap_global"}, true
+}
+
+func (zzzGame) EndgameModes() []endgameDefinition { return zzzEndgame }
+
+func (zzzGame) RecordURL(region Region, path string) string {
+	if region == RegionChina {
+		return cnZZZRecordURL + "/" + path
+	}
+	return zzzRecordURL + "/" + path
+}
+
+func (zzzGame) SetHeaders(h http.Header, config GameConfig) {
+	h.Set("x-rpc-page", "v1.7.1_#/zzz")
+	h.Set("x-rpc-geetest_ext", `{"viewUid":"33046672","server":"prod_gf_us","gameId":8,"page":"v1.7.1_#/zzz","isHost":1,"viewSource":1,"actionSource":127}`)
+}
+
+func (zzzGame) DecodeNote(data []byte) (Note, error) {
+	var result DailyNoteResponseZZZ
+	err := json.Unmarshal(data, &result)
+	return &result, err
+}
+
+type DailyNoteResponseZZZ struct {
+	Retcode int    `json:"retcode"`
+	Message string `json:"message"`
+	Data    struct {
+		Energy struct {
+			Progress struct {
+				Max     int `json:"max"`
+				Current int `json:"current"`
+			} `json:"progress"`
+			Restore int `json:"restore"`
+			DayType int `json:"day_type"`
+			Hour    int `json:"hour"`
+			Minute  int `json:"minute"`
+		} `json:"energy"`
+		Vitality struct {
+			Max     int `json:"max"`
+			Current int `json:"current"`
+		} `json:"vitality"`
+		VhsSale struct {
+			SaleState string `json:"sale_state"`
+		} `json:"vhs_sale"`
+		CardSign         string `json:"card_sign"`
+		BountyCommission struct {
+			Num         int `json:"num"`
+			Total       int `json:"total"`
+			RefreshTime int `json:"refresh_time"`
+		} `json:"bounty_commission"`
+		SurveyPoints any `json:"survey_points"`
+		AbyssRefresh int `json:"abyss_refresh"`
+		Coffee       any `json:"coffee"`
+		WeeklyTask   struct {
+			RefreshTime int `json:"refresh_time"`
+			CurPoint    int `json:"cur_point"`
+			MaxPoint    int `json:"max_point"`
+		} `json:"weekly_task"`
+		MemberCard struct {
+			IsOpen          bool   `json:"is_open"`
+			MemberCardState string `json:"member_card_state"`
+			ExpTime         string `json:"exp_time"`
+		} `json:"member_card"`
+		IsSub      bool `json:"is_sub"`
+		IsOtherSub bool `json:"is_other_sub"`
+	} `json:"data"`
+}
+
+func (r *DailyNoteResponseZZZ) Stamina() (int, int) {
+	return r.Data.Energy.Progress.Current, r.Data.Energy.Progress.Max
+}
+
+func (r *DailyNoteResponseZZZ) RecoverySeconds() int {
+	return r.Data.Energy.Restore
+}
+
+func (r *DailyNoteResponseZZZ) Todos(now time.Time) []TodoItem {
+	d := r.Data
+
+	ridu := newTodo(ZZZ, "ridu_weekly", "Ridu Weekly Points", d.WeeklyTask.CurPoint, d.WeeklyTask.MaxPoint, ResetWeekly)
+	if d.WeeklyTask.RefreshTime > 0 {
+		ridu.ResetsAt = now.Add(time.Duration(d.WeeklyTask.RefreshTime) * time.Second).Unix()
+	}
+
+	return []TodoItem{
+		newTodo(ZZZ, "engagement", "Daily Engagement", d.Vitality.Current, d.Vitality.Max, ResetDaily),
+		ridu,
+	}
+}
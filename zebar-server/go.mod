@@ -5,6 +5,7 @@ go 1.24.1
 require (
 	github.com/go-ole/go-ole v1.3.0
 	github.com/gorilla/websocket v1.5.3
+	golang.org/x/term v0.40.0
 )
 
-require golang.org/x/sys v0.1.0 // indirect
+require golang.org/x/sys v0.41.0 // indirect
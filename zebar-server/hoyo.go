@@ -2,56 +2,99 @@ package main
 
 import (
 	"context"
-	"crypto/md5"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"math/rand"
 	"net/http"
+	"net/url"
 	"sync"
 	"time"
-
-	"github.com/gorilla/websocket"
 )
 
+// Publisher delivers an encoded message to the clients subscribed to topic.
+type Publisher interface {
+	Publish(topic string, data []byte)
+}
+
+// ResinUpdater keeps the latest note and endgame status for every account
+// and publishes them as they change. It is shared by all clients, which get
+// the current state from it when they subscribe.
 type ResinUpdater struct {
+	ctx context.Context
+	pub Publisher
+
 	mu      sync.Mutex
 	notes   map[GameId]DailyNoteCommon
+	endgame map[GameId][]EndgameMode
 	cancels map[GameId]context.CancelFunc
+
+	// every goroutine the updater started, see Wait
+	wg sync.WaitGroup
 }
 
-func NewResinUpdater() *ResinUpdater {
+// NewResinUpdater creates an updater whose stamina timers run until ctx is
+// cancelled.
+func NewResinUpdater(ctx context.Context, pub Publisher) *ResinUpdater {
 	return &ResinUpdater{
+		ctx:     ctx,
+		pub:     pub,
 		notes:   make(map[GameId]DailyNoteCommon),
+		endgame: make(map[GameId][]EndgameMode),
 		cancels: make(map[GameId]context.CancelFunc),
 	}
 }
 
-func (u *ResinUpdater) RunDailyNoteUpdates(conn *websocket.Conn, config GameConfig) error {
-	note, err := DailyNote(config)
+// RunDailyNoteUpdates fetches the note for config, ctx only bounds the
+// fetch.
+func (u *ResinUpdater) RunDailyNoteUpdates(ctx context.Context, config GameConfig) error {
+	note, err := DailyNote(ctx, config)
 	if err != nil {
 		return err
 	}
 
-	go u.Run(conn, note)
+	u.spawn(func() { u.Run(note) })
 
 	return nil
 }
 
-func writeNoteToConn(conn *websocket.Conn, note DailyNoteCommon) error {
-	return conn.WriteJSON(struct {
-		Curr int    `json:"curr"`
-		Max  int    `json:"max"`
-		Game string `json:"game"`
-	}{
+func (u *ResinUpdater) spawn(f func()) {
+	u.wg.Add(1)
+	go func() {
+		defer u.wg.Done()
+		f()
+	}()
+}
+
+// Wait blocks until every goroutine the updater started has returned, which
+// they do soon after its context is cancelled.
+func (u *ResinUpdater) Wait() {
+	u.wg.Wait()
+}
+
+type StaminaPayload struct {
+	Game GameId `json:"game"`
+	Curr int    `json:"curr"`
+	Max  int    `json:"max"`
+}
+
+func (u *ResinUpdater) publish(topic string, payload any) {
+	data, err := encodeEvent(topic, payload)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	u.pub.Publish(topic, data)
+}
+
+func staminaPayload(note DailyNoteCommon) StaminaPayload {
+	return StaminaPayload{
+		Game: note.Game,
 		Curr: note.Current,
 		Max:  note.Max,
-		Game: string(note.Game),
-	})
+	}
 }
 
-func (ru *ResinUpdater) Run(conn *websocket.Conn, note DailyNoteCommon) {
+func (ru *ResinUpdater) Run(note DailyNoteCommon) {
 
 	ru.mu.Lock()
 
@@ -60,23 +103,28 @@ func (ru *ResinUpdater) Run(conn *websocket.Conn, note DailyNoteCommon) {
 	}
 
 	ru.notes[note.Game] = note
-	if err := writeNoteToConn(conn, note); err != nil {
-		ru.mu.Unlock()
-		return
-	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ru.ctx)
 	defer cancel()
 	ru.cancels[note.Game] = cancel
 
 	ru.mu.Unlock()
 
+	// published outside the lock, subscribing clients take it for snapshots
+	// while holding their write lock
+	ru.publish(StaminaTopic(note.Game), staminaPayload(note))
+	ru.publish(ChecklistTopic(note.Game), checklistPayload(note))
+
 	if note.Current >= note.Max {
 		return
 	}
 
 	rem := note.FullyRecoveredTs % int(note.RecoverInterval.Seconds())
-	time.Sleep(time.Duration(rem) * time.Second)
+	select {
+	case <-time.After(time.Duration(rem) * time.Second):
+	case <-ctx.Done():
+		return
+	}
 
 	ticker := time.NewTicker(note.RecoverInterval)
 	defer ticker.Stop()
@@ -94,18 +142,13 @@ func (ru *ResinUpdater) Run(conn *websocket.Conn, note DailyNoteCommon) {
 
 			n.Current += 1
 			ru.notes[note.Game] = n
-			if err := writeNoteToConn(conn, n); err != nil {
-				ru.mu.Unlock()
-				return
-			}
+			ru.mu.Unlock()
 
+			ru.publish(StaminaTopic(n.Game), staminaPayload(n))
 			if n.Current >= n.Max {
-				ru.mu.Unlock()
 				return
 			}
 
-			ru.mu.Unlock()
-
 		case <-ctx.Done():
 			ticker.Stop()
 			return
@@ -113,122 +156,315 @@ func (ru *ResinUpdater) Run(conn *websocket.Conn, note DailyNoteCommon) {
 	}
 }
 
-func buildRequest(config GameConfig) (req *http.Request, err error) {
-	ds := generateDS()
+// Watch fetches every account and keeps them current, refetching after the
+// daily reset, when a game is closed and when a captcha is solved.
+func (u *ResinUpdater) Watch(m *Monitor) {
+	ctx := u.ctx
 
-	switch config.game {
-	case ZZZ:
-		req, err = http.NewRequest("GET", "https://sg-public-api.hoyolab.com/event/game_record_zzz/api/zzz/note?server=prod_gf_us&role_id=1000482805", nil)
-		if err != nil {
-			return nil, err
+	for _, config := range Configs() {
+		u.spawn(func() { u.RunDailyNoteUpdates(ctx, config) })
+		u.spawn(func() { u.RunEndgameUpdates(ctx, config) })
+		u.spawn(func() { u.RunResetRefresh(ctx, config) })
+	}
+
+	listen := m.Register()
+	defer m.Unregister(listen)
+
+	resolved := captchas.Register()
+	defer captchas.Unregister(resolved)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case config, ok := <-resolved:
+			if !ok {
+				return
+			}
+			u.spawn(func() { u.RunDailyNoteUpdates(ctx, config) })
+			u.spawn(func() { u.RunEndgameUpdates(ctx, config) })
+		case event := <-listen:
+			if event.Type == StopEvent {
+				game, ok := GameByProcess(event.Name)
+				if !ok {
+					continue
+				}
+
+				log.Println("refetching after stop event")
+				for _, config := range accountsFor(game.Id()) {
+					u.spawn(func() { u.RunDailyNoteUpdates(ctx, config) })
+				}
+			}
+		}
+	}
+}
+
+// snapshot encodes the current state of every game matching topic, which is
+// either a family such as "stamina" or a single game's topic.
+func (u *ResinUpdater) snapshot(topic string, gameTopic func(GameId) string, payload func(GameId) (any, bool)) [][]byte {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var messages [][]byte
+	for _, config := range Configs() {
+		t := gameTopic(config.game)
+		if topic != t && topicFamily(t) != topic {
+			continue
 		}
-		req.Header.Set("DS", ds)
-		req.Header.Set("Cookie", config.cookie)
-		req.Header.Set("x-rpc-page", "v1.7.1_#/zzz")
-		req.Header.Set("x-rpc-geetest_ext", `{"viewUid":"33046672","server":"prod_gf_us","gameId":8,"page":"v1.7.1_#/zzz","isHost":1,"viewSource":1,"actionSource":127}`)
-		req.Header.Set("x-rpc-client_type", "5")
-		req.Header.Set("x-rpc-language", "en-us")
-		req.Header.Set("User-Agent", "Mozilla/5.0")
-	case STARRAIL, GENSHIN:
-		url := fmt.Sprintf(
-			"https://bbs-api-os.hoyolab.com/game_record/%s/api/%s?role_id=%s&server=%s",
-			config.gamePath,
-			config.path,
-			config.uid,
-			config.server,
-		)
-		req, err = http.NewRequest("GET", url, nil)
+		p, ok := payload(config.game)
+		if !ok {
+			continue
+		}
+		data, err := encodeEvent(t, p)
 		if err != nil {
-			return nil, err
+			log.Println(err)
+			continue
 		}
-		req.Header.Set("DS", ds)
-		req.Header.Set("Cookie", config.cookie)
-		req.Header.Set("x-rpc-client_type", "5")
-		req.Header.Set("x-rpc-language", "en-us")
-		req.Header.Set("User-Agent", "Mozilla/5.0")
-		req.Header.Set("x-rpc-app_version", config.version)
+		messages = append(messages, data)
+	}
+	return messages
+}
+
+// GameState is what the updater last saw for a game, fields are nil until
+// the first successful fetch.
+type GameState struct {
+	Game         GameId            `json:"game"`
+	Stamina      *StaminaPayload   `json:"stamina"`
+	Checklist    *ChecklistPayload `json:"checklist"`
+	Endgame      []EndgameMode     `json:"endgame"`
+	Verification *Verification     `json:"verification,omitempty"`
+}
 
+func (u *ResinUpdater) State(config GameConfig) GameState {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	state := GameState{Game: config.game, Endgame: u.endgame[config.game]}
+	if note, ok := u.notes[config.game]; ok {
+		stamina, checklist := staminaPayload(note), checklistPayload(note)
+		state.Stamina, state.Checklist = &stamina, &checklist
 	}
-	return req, nil
+	if v, paused := captchas.Paused(config); paused {
+		state.Verification = &v
+	}
+	return state
+}
+
+func (u *ResinUpdater) StaminaSnapshot(topic string) [][]byte {
+	return u.snapshot(topic, StaminaTopic, func(game GameId) (any, bool) {
+		note, ok := u.notes[game]
+		return staminaPayload(note), ok
+	})
 }
 
-func DailyNote(config GameConfig) (DailyNoteCommon, error) {
+func (u *ResinUpdater) ChecklistSnapshot(topic string) [][]byte {
+	return u.snapshot(topic, ChecklistTopic, func(game GameId) (any, bool) {
+		note, ok := u.notes[game]
+		return checklistPayload(note), ok
+	})
+}
 
-	req, err := buildRequest(config)
-	if err != nil {
-		return DailyNoteCommon{}, err
+func (u *ResinUpdater) EndgameSnapshot(topic string) [][]byte {
+	return u.snapshot(topic, EndgameTopic, func(game GameId) (any, bool) {
+		modes, ok := u.endgame[game]
+		return EndgamePayload{Game: game, Modes: modes}, ok
+	})
+}
+
+var (
+	recordURL    = "https://bbs-api-os.hoyolab.com/game_record"
+	zzzRecordURL = "https://sg-public-api.hoyolab.com/event/game_record_zzz/api/zzz"
+
+	cnRecordURL    = "https://api-takumi-record.mihoyo.com/game_record/app"
+	cnZZZRecordURL = "https://api-takumi-record.mihoyo.com/event/game_record_zzz/api/zzz"
+)
+
+func noteQuery(config GameConfig) url.Values {
+	query := url.Values{}
+	query.Set("role_id", config.uid)
+	query.Set("server", config.server)
+	return query
+}
+
+func buildRequest(ctx context.Context, config GameConfig) (*http.Request, error) {
+	game, ok := GameById(config.game)
+	if !ok {
+		return nil, fmt.Errorf("unknown game %s", config.game)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	return buildRecordRequest(ctx, config, game.NotePath(), noteQuery(config))
+}
+
+func buildRecordRequest(ctx context.Context, config GameConfig, path string, query url.Values) (*http.Request, error) {
+	game, ok := GameById(config.game)
+	if !ok {
+		return nil, fmt.Errorf("unknown game %s", config.game)
+	}
+
+	region := config.Region()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", game.RecordURL(region, path)+"?"+query.Encode(), nil)
 	if err != nil {
-		return DailyNoteCommon{}, err
+		return nil, err
+	}
+
+	if region == RegionChina {
+		req.Header.Set("DS", generateDS2(dsSaltCN, "", query))
+		req.Header.Set("Referer", "https://webstatic.mihoyo.com/")
+	} else {
+		req.Header.Set("DS", generateDS())
+	}
+	req.Header.Set("Cookie", config.Cookie())
+	req.Header.Set("x-rpc-client_type", "5")
+	req.Header.Set("x-rpc-language", "en-us")
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+
+	d := currentDevice()
+	req.Header.Set("x-rpc-device_id", d.Id)
+	if fp, err := d.Fingerprint(ctx, region); err != nil {
+		log.Println("device fingerprint:", err)
+	} else {
+		req.Header.Set("x-rpc-device_fp", fp)
 	}
-	defer resp.Body.Close()
 
-	log.Println("Fetched note for ", config.game, " status: ", resp.Status)
+	game.SetHeaders(req.Header, config)
 
-	note := DailyNoteCommon{
-		Game:            config.game,
-		RecoverInterval: config.resinRecharge,
+	return req, nil
+}
+
+type recordResponse struct {
+	Retcode int             `json:"retcode"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type APIError struct {
+	Game    GameId
+	Path    string
+	Retcode int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s failed: %d %s", e.Game, e.Path, e.Retcode, e.Message)
+}
+
+// doRecord fetches a game record endpoint and returns the raw response.
+// Identical fetches made at the same time or shortly after one another share
+// a single request.
+func doRecord(ctx context.Context, config GameConfig, path string, query url.Values) ([]byte, error) {
+	key := recordAccount(config) + path + "?" + query.Encode()
+	return records.Do(ctx, key, func(ctx context.Context) ([]byte, error) {
+		return requestRecord(ctx, config, path, query)
+	})
+}
+
+// recordAccount prefixes the records key of every fetch for config.
+func recordAccount(config GameConfig) string {
+	return config.game + "/" + config.uid + "/" + config.server + "/"
+}
+
+// requestRecord requests a game record endpoint from HoYoLAB. A
+// rejected cookie is refreshed from the stoken and a 1034, which usually
+// means the device fingerprint went stale, refreshes the fingerprint. Either
+// way the request is retried once, a second 1034 pauses the account until
+// the captcha is solved.
+func requestRecord(ctx context.Context, config GameConfig, path string, query url.Values) ([]byte, error) {
+	if v, ok := captchas.Paused(config); ok {
+		return nil, &VerificationError{Verification: v}
 	}
 
-	switch config.game {
-	case GENSHIN:
-		var result DailyNoteResponseGenshin
-		bytes, err := io.ReadAll(resp.Body)
+	for attempt := 0; ; attempt++ {
+		req, err := buildRecordRequest(ctx, config, path, query)
 		if err != nil {
-			return DailyNoteCommon{}, err
+			return nil, err
 		}
-		err = json.Unmarshal(bytes, &result)
+
+		resp, bytes, err := hoyo.Do(req)
 		if err != nil {
-			return DailyNoteCommon{}, err
+			return nil, err
 		}
-		note.Current = result.Data.CurrentResin
-		note.Max = result.Data.MaxResin
-	case STARRAIL:
-		var result DailyNoteResponseStarRail
-		bytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return DailyNoteCommon{}, err
+
+		log.Println("Fetched", path, "for ", config.game, " status: ", resp.Status)
+
+		var result recordResponse
+		if err := json.Unmarshal(bytes, &result); err != nil {
+			return nil, err
 		}
-		err = json.Unmarshal(bytes, &result)
-		if err != nil {
-			return DailyNoteCommon{}, err
+
+		if cookieRejected(result.Retcode) && attempt == 0 {
+			if creds, ok := credentials[config.Region()]; ok {
+				log.Println("cookie rejected for", config.game, "refreshing from stoken")
+				err := creds.RefreshRejected(ctx, req.Header.Get("Cookie"))
+				if err == nil {
+					continue
+				}
+				log.Println("cookie refresh:", err)
+			}
 		}
-		note.Current = result.Data.CurrentStamina
-		note.Max = result.Data.MaxStamina
-	case ZZZ:
-		var result DailyNoteResponseZZZ
-		bytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return DailyNoteCommon{}, err
+		if result.Retcode == retcodeCaptcha && attempt == 0 {
+			log.Println("device fingerprint rejected for", config.game, "refreshing")
+			_, err := currentDevice().RefreshFingerprint(ctx, config.Region())
+			if err == nil {
+				continue
+			}
+			log.Println("device fingerprint:", err)
 		}
-		err = json.Unmarshal(bytes, &result)
-		if err != nil {
-			return DailyNoteCommon{}, err
+		if result.Retcode == retcodeCaptcha {
+			captchas.Require(ctx, config)
 		}
-		note.Current = result.Data.Energy.Progress.Current
-		note.Max = result.Data.Energy.Progress.Max
+		if result.Retcode != 0 {
+			return nil, &APIError{Game: config.game, Path: path, Retcode: result.Retcode, Message: result.Message}
+		}
+
+		captchas.Succeeded(config)
+		return bytes, nil
 	}
+}
 
-	note.RecoverInterval = config.resinRecharge
+func fetchRecord(ctx context.Context, config GameConfig, path string, query url.Values, v any) error {
+	bytes, err := doRecord(ctx, config, path, query)
+	if err != nil {
+		return err
+	}
 
-	return note, nil
+	var result recordResponse
+	if err := json.Unmarshal(bytes, &result); err != nil {
+		return err
+	}
+	return json.Unmarshal(result.Data, v)
 }
 
-func generateDS() string {
-	t := time.Now().Unix()
+func DailyNote(ctx context.Context, config GameConfig) (DailyNoteCommon, error) {
+	game, ok := GameById(config.game)
+	if !ok {
+		return DailyNoteCommon{}, fmt.Errorf("unknown game %s", config.game)
+	}
+
+	bytes, err := doRecord(ctx, config, game.NotePath(), noteQuery(config))
+	if err != nil {
+		return DailyNoteCommon{}, err
+	}
+
+	result, err := game.DecodeNote(bytes)
+	if err != nil {
+		return DailyNoteCommon{}, err
+	}
 
-	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	now := time.Now()
 
-	random := make([]byte, 6)
-	for i := range 6 {
-		random[i] = letters[rand.Intn(len(letters))]
+	note := DailyNoteCommon{
+		Game:            config.game,
+		Server:          config.server,
+		RecoverInterval: game.Recharge(),
+		Todos:           result.Todos(now),
+	}
+	note.Current, note.Max = result.Stamina()
+	if r, ok := result.(RecoveringNote); ok {
+		note.FullyRecoveredTs = r.RecoverySeconds()
 	}
 
-	// Format string to hash
-	raw := fmt.Sprintf("salt=%s&t=%d&r=%s", dsSalt, t, string(random))
-	hash := fmt.Sprintf("%x", md5.Sum([]byte(raw)))
+	applyResets(note.Todos, config.server, now)
 
-	return fmt.Sprintf("%d,%s,%s", t, string(random), hash)
+	return note, nil
 }
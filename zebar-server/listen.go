@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+var (
+	unixPath = flag.String("unix", "", "also listen on a Unix domain socket at this path")
+	unixMode = flag.Uint("unix-mode", 0o600, "file mode of the -unix socket, which decides who may connect without a key, Windows sockets always need one")
+	tlsAddr  = flag.String("tls-addr", "", "also serve TLS on this address, for other machines on the LAN, needs API keys")
+	tlsCert  = flag.String("tls-cert", "", "certificate for -tls-addr, a self-signed one is made in -state when empty")
+	tlsKey   = flag.String("tls-key", "", "private key for -tls-cert")
+)
+
+// how long a generated certificate is valid, it is replaced once expired
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// listener is one address the server answers on.
+type listener struct {
+	net.Listener
+	// how to reach it, for the log
+	url string
+}
+
+// listen opens every listener the flags ask for. -addr may be emptied to
+// serve only on the others.
+func listen() ([]listener, error) {
+	if err := checkExposure(*addr, *tlsAddr, auth); err != nil {
+		return nil, err
+	}
+
+	var listeners []listener
+	fail := func(err error) ([]listener, error) {
+		for _, l := range listeners {
+			l.Close()
+		}
+		return nil, err
+	}
+
+	if *addr != "" {
+		ln, err := net.Listen("tcp", *addr)
+		if err != nil {
+			return fail(err)
+		}
+		listeners = append(listeners, listener{ln, "http://" + *addr})
+	}
+	if *unixPath != "" {
+		ln, err := listenUnix(*unixPath, fs.FileMode(*unixMode))
+		if err != nil {
+			return fail(err)
+		}
+		listeners = append(listeners, listener{ln, "unix:" + *unixPath})
+	}
+	if *tlsAddr != "" {
+		config, err := tlsConfig(*tlsCert, *tlsKey, *stateDir)
+		if err != nil {
+			return fail(err)
+		}
+		ln, err := tls.Listen("tcp", *tlsAddr, config)
+		if err != nil {
+			return fail(err)
+		}
+		listeners = append(listeners, listener{ln, "https://" + *tlsAddr})
+	}
+
+	if len(listeners) == 0 {
+		return nil, errors.New("nothing to listen on, set -addr, -unix or -tls-addr")
+	}
+	return listeners, nil
+}
+
+// checkExposure refuses to serve other machines without API keys and a
+// media source key, or every one of them would get full control or could
+// pose as the music player.
+func checkExposure(addr, tlsAddr string, a *Auth) error {
+	var exposed string
+	switch {
+	case addr != "" && !loopbackAddr(addr):
+		exposed = "-addr " + addr + " is reachable from other machines"
+	case tlsAddr != "":
+		exposed = "-tls-addr serves other machines"
+	default:
+		return nil
+	}
+
+	if a.Open() {
+		return errors.New(exposed + ", configure an API_KEY_<name> first")
+	}
+	if a.mediaKey == "" {
+		return errors.New(exposed + ", configure MEDIA_SOURCE_KEY first")
+	}
+	return nil
+}
+
+// loopbackAddr reports whether addr only takes connections from this machine.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// listenUnix listens on a socket at path that only users allowed by mode can
+// connect to. A socket left behind by an earlier run is removed first.
+func listenUnix(path string, mode fs.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// file modes mean nothing to Windows, so localSocket does not trust the
+	// socket there either
+	if runtime.GOOS == "windows" {
+		return net.Listen("unix", path)
+	}
+
+	// the socket is made in a directory nobody else can enter and only moved
+	// to path once it has its mode, so there is no moment anyone can connect
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".zbserv-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "sock")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	ln.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return &unixListener{ln, path}, nil
+}
+
+// unixListener removes its socket on Close, which net.UnixListener cannot do
+// once the socket has been renamed.
+type unixListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+type localSocketKey struct{}
+
+// markLocalSocket is the http.Server ConnContext, it flags connections that
+// came in over the Unix socket, except on Windows.
+func markLocalSocket(ctx context.Context, c net.Conn) context.Context {
+	if c.LocalAddr().Network() == "unix" && runtime.GOOS != "windows" {
+		return context.WithValue(ctx, localSocketKey{}, true)
+	}
+	return ctx
+}
+
+// localSocket reports whether r came in over the Unix socket, whose file
+// mode already decided who may connect.
+func localSocket(r *http.Request) bool {
+	local, _ := r.Context().Value(localSocketKey{}).(bool)
+	return local
+}
+
+// tlsConfig uses the given certificate, or a self-signed one kept in dir.
+func tlsConfig(certFile, keyFile, dir string) (*tls.Config, error) {
+	if certFile == "" {
+		certFile, keyFile = filepath.Join(dir, "tls_cert.pem"), filepath.Join(dir, "tls_key.pem")
+		if err := ensureSelfSigned(certFile, keyFile, time.Now()); err != nil {
+			return nil, fmt.Errorf("self-signed certificate: %w", err)
+		}
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(cert.Certificate[0])
+	log.Printf("TLS certificate %s, SHA-256 fingerprint %s", certFile, hex.EncodeToString(sum[:]))
+
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// ensureSelfSigned writes a new certificate unless a current one is already
+// there, so clients that trusted it keep doing so across restarts.
+func ensureSelfSigned(certFile, keyFile string, now time.Time) error {
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil && now.Before(cert.Leaf.NotAfter) {
+		return nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "zbserv"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(selfSignedValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+	}
+	if host, err := os.Hostname(); err == nil {
+		template.DNSNames = append(template.DNSNames, host)
+	}
+	// every address the machine has, so it can be reached by IP on the LAN
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ip, ok := a.(*net.IPNet); ok {
+				template.IPAddresses = append(template.IPAddresses, ip.IP)
+			}
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(certFile), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// unix socket paths are limited to around a hundred bytes, which t.TempDir
+// can exceed
+func shortTempDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "zb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestListenUnix(t *testing.T) {
+	dir := shortTempDir(t)
+	path := filepath.Join(dir, "zbserv.sock")
+
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenUnix(path, 0o600); err == nil {
+		t.Fatal("replaced a file that is not a socket")
+	}
+	os.Remove(path)
+
+	ln, err := listenUnix(path, 0o660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0o660 {
+			t.Errorf("mode %v", info.Mode().Perm())
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("left behind %v", entries)
+	}
+	ln.Close()
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("socket kept after Close: %v", err)
+	}
+
+	// a crashed run leaves its socket behind
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+	ln, err = listenUnix(path, 0o600)
+	if err != nil {
+		t.Fatalf("stale socket: %v", err)
+	}
+	ln.Close()
+}
+
+func TestCheckExposure(t *testing.T) {
+	open, keyed := NewAuth(), testAuth(t)
+	noMedia := &Auth{keys: keyed.keys}
+
+	tests := []struct {
+		addr, tlsAddr string
+		auth          *Auth
+		ok            bool
+	}{
+		{"localhost:45456", "", open, true},
+		{"127.0.0.1:45456", "", open, true},
+		{"[::1]:45456", "", open, true},
+		{"", "", open, true},
+		{":45456", "", open, false},
+		{"0.0.0.0:45456", "", open, false},
+		{"192.168.1.2:45456", "", open, false},
+		{"localhost:45456", ":45457", open, false},
+		{":45456", ":45457", keyed, true},
+		{":45456", "", noMedia, false},
+		{"localhost:45456", ":45457", noMedia, false},
+		{"localhost:45456", "", noMedia, true},
+	}
+	for _, tt := range tests {
+		if err := checkExposure(tt.addr, tt.tlsAddr, tt.auth); (err == nil) != tt.ok {
+			t.Errorf("%q %q open %v: err = %v", tt.addr, tt.tlsAddr, tt.auth.Open(), err)
+		}
+	}
+}
+
+func TestEnsureSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	now := time.Now()
+
+	if err := ensureSelfSigned(certFile, keyFile, now); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := os.ReadFile(certFile)
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.Leaf.VerifyHostname("localhost"); err != nil {
+		t.Error(err)
+	}
+	if err := cert.Leaf.VerifyHostname("127.0.0.1"); err != nil {
+		t.Error(err)
+	}
+
+	if err := ensureSelfSigned(certFile, keyFile, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.ReadFile(certFile); !bytes.Equal(first, again) {
+		t.Error("a current certificate was replaced")
+	}
+
+	if err := ensureSelfSigned(certFile, keyFile, now.Add(selfSignedValidity+time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.ReadFile(certFile); bytes.Equal(first, again) {
+		t.Error("an expired certificate was kept")
+	}
+}
+
+func TestServeUnixAndTLS(t *testing.T) {
+	withRunGlobals(t)
+	withAuth(t, testAuth(t))
+
+	path := filepath.Join(shortTempDir(t), "zbserv.sock")
+	unixLn, err := listenUnix(path, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	config, err := tlsConfig("", "", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsLn, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- run(ctx, unixLn, tlsLn) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-stopped; err != nil {
+			t.Error(err)
+		}
+	})
+
+	unixClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	pem, err := os.ReadFile(filepath.Join(dir, "tls_cert.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(pem)
+	tlsClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	tlsURL := "https://" + tlsLn.Addr().String()
+
+	// the socket's file mode is what keeps others out, Windows has none
+	unixStatus := http.StatusOK
+	if runtime.GOOS == "windows" {
+		unixStatus = http.StatusUnauthorized
+	}
+
+	tests := []struct {
+		name   string
+		client *http.Client
+		url    string
+		want   int
+	}{
+		{"unix without a key", unixClient, "http://zbserv/api/health", unixStatus},
+		{"tls without a key", tlsClient, tlsURL + "/api/health", http.StatusUnauthorized},
+		{"tls with a key", tlsClient, tlsURL + "/api/health?key=phone-secret-key", http.StatusOK},
+	}
+	for _, tt := range tests {
+		resp, err := tt.client.Get(tt.url)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+		tt.client.CloseIdleConnections()
+	}
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -16,193 +18,422 @@ import (
 )
 
 type Server struct {
-	ytmusic *websocket.Conn
+	// the media source's writes go through its own queue, like any client
+	ytmusic *Client
+
+	clients   map[*Client]bool
+	events    *eventLog
+	media     json.RawMessage
+	snapshots map[string]SnapshotFunc
+	mu        sync.Mutex
+}
+
+var errNoMediaSource = errors.New("no media source connected")
 
-	conns map[*websocket.Conn]struct{}
-	last  []byte
-	mu    sync.Mutex
+func (s *Server) TogglePlayback() error {
+	s.mu.Lock()
+	source := s.ytmusic
+	s.mu.Unlock()
+	log.Println("checking yt conn")
+	if source == nil {
+		return errNoMediaSource
+	}
+	log.Println("sending toggle playback to yt-music")
+	return source.Write(make([]byte, 0))
 }
 
-func (s *Server) TogglePlayback() {
+const MediaSourceDisconnected = "source-disconnected"
+
+// MediaSourceStatus tells clients the media source went away, so whatever it
+// last reported is no longer playing.
+type MediaSourceStatus struct {
+	Kind string `json:"kind"`
+}
+
+// SetMediaSource makes source the media source, replacing any earlier one.
+func (s *Server) SetMediaSource(source *Client) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	log.Println("checking yt conn")
-	if s.ytmusic != nil {
-		log.Println("sending toggle playback to yt-music")
-		s.ytmusic.WriteMessage(websocket.TextMessage, make([]byte, 0))
+
+	s.ytmusic = source
+}
+
+// DropMediaSource forgets source and what it was playing, and tells clients.
+// Nothing happens if another source has replaced it since.
+func (s *Server) DropMediaSource(source *Client) {
+	s.mu.Lock()
+	if s.ytmusic != source {
+		s.mu.Unlock()
+		return
 	}
+	s.ytmusic = nil
+	s.media = nil
+	s.mu.Unlock()
+
+	data, err := encodeEvent(TopicMedia, MediaSourceStatus{Kind: MediaSourceDisconnected})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	s.Publish(TopicMedia, data)
 }
 
-func (s *Server) Remove(conn *websocket.Conn) {
+func (s *Server) Remove(c *Client) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	delete(s.conns, conn)
+	delete(s.clients, c)
 }
 
-func (s *Server) Add(conn *websocket.Conn) {
+func (s *Server) Add(c *Client) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if len(s.last) != 0 {
-		conn.WriteMessage(websocket.TextMessage, s.last)
+	s.clients[c] = true
+}
+
+// Broadcast publishes what the media source is playing and keeps it for
+// clients that subscribe later.
+func (s *Server) Broadcast(media json.RawMessage) {
+	data, err := encodeEvent(TopicMedia, media)
+	if err != nil {
+		log.Println(err)
+		return
 	}
-	s.conns[conn] = struct{}{}
+
+	s.mu.Lock()
+	s.media = media
+	s.mu.Unlock()
+
+	s.Publish(TopicMedia, data)
 }
 
-func (s *Server) Broadcast(data []byte) {
+func (s *Server) NowPlaying() (json.RawMessage, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.last = data
+	return s.media, len(s.media) > 0
+}
 
-	for conn := range s.conns {
-		conn.WriteMessage(websocket.TextMessage, data)
+func (s *Server) mediaSnapshot(string) [][]byte {
+	media, ok := s.NowPlaying()
+	if !ok {
+		return nil
+	}
+	data, err := encodeEvent(TopicMedia, media)
+	if err != nil {
+		log.Println(err)
+		return nil
 	}
+	return [][]byte{data}
+}
+
+func (s *Server) MediaConnected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ytmusic != nil
+}
+
+func (s *Server) ClientCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.clients)
 }
 
 func NewServer() *Server {
-	return &Server{
-		last:  make([]byte, 0),
-		conns: map[*websocket.Conn]struct{}{},
+	s := &Server{
+		clients:   map[*Client]bool{},
+		events:    newEventLog(*eventLogSize),
+		snapshots: map[string]SnapshotFunc{},
 	}
+	s.snapshots[TopicMedia] = s.mediaSnapshot
+	return s
 }
 
 func main() {
 	flag.Parse()
+	log.SetOutput(redactWriter{w: os.Stderr})
 
-	ctx := context.Background()
+	if flag.Arg(0) == "encrypt-secret" {
+		os.Exit(runEncryptSecretCommand(flag.Args()[1:]))
+	}
+	if flag.Arg(0) == "protocol" {
+		os.Exit(runProtocolCommand(flag.Args()[1:]))
+	}
 
-	monitor := NewMonitor(ctx)
+	if err := LoadConfig(*configPath); err != nil {
+		log.Fatal(err)
+	}
+	if auth.Open() {
+		log.Println("no API keys configured, every local client has full control")
+	}
+	hoyo = newHoyoClient(*requestTimeout)
+	hoyo.limiter = newTokenBucket(*rateLimit, *rateBurst)
+	records = newRecordGroup(*cacheTTL)
+
+	if flag.Arg(0) == "redeem" {
+		os.Exit(runRedeemCommand(flag.Args()[1:]))
+	}
+
+	LoadCredentials(*stateDir)
 
-	go monitor.Run()
-	defer monitor.Stop()
+	// cancelled on SIGINT or SIGTERM, which starts the shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listeners, err := listen()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var lns []net.Listener
+	for _, l := range listeners {
+		log.Printf("Server is running on %s", l.url)
+		lns = append(lns, l)
+	}
+
+	if err := run(ctx, lns...); err != nil {
+		log.Printf("Server error: %v", err)
+		os.Exit(1)
+	}
+	log.Println("Server exited properly")
+}
+
+// run serves on lns until ctx is cancelled and then shuts everything down:
+// websocket clients get a going away close frame, requests in flight get
+// -shutdown-timeout to finish and the monitor, updaters and client writers
+// are waited for.
+func run(ctx context.Context, lns ...net.Listener) error {
+	// also cancelled if the server fails, stopping every fetch in flight
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var background sync.WaitGroup
+	spawn := func(f func()) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			f()
+		}()
+	}
+
+	monitor := NewMonitor(ctx)
+	spawn(monitor.Run)
 
 	serv := NewServer()
 
-	http.HandleFunc("/ytmusic", func(w http.ResponseWriter, r *http.Request) {
-		conn, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			log.Println(err)
-			return
-		}
-		defer conn.Close()
+	captchas.SetPublisher(serv.Publish)
+	alerts.SetPublisher(serv.Publish)
 
-		serv.ytmusic = conn
+	for _, c := range credentials {
+		c.SetPublisher(serv.Publish)
+	}
+	spawn(func() { RunCookieHealth(ctx) })
 
-		for {
-			mtype, b, err := conn.ReadMessage()
-			if err != nil {
-				return
-			}
-			switch mtype {
-			case websocket.TextMessage:
-				log.Println("recieved ytmusic msg: ", string(b))
-				serv.Broadcast(b)
-			}
-		}
-	})
+	checkins := NewCheckInScheduler(serv)
+	spawn(func() { checkins.Run(ctx, Configs()) })
+
+	updater := NewResinUpdater(ctx, serv)
+	spawn(func() { updater.Watch(monitor) })
+	spawn(func() { monitor.PublishEvents(serv) })
 
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		serveWs(w, r, monitor, serv)
+	serv.AddSnapshot("stamina", updater.StaminaSnapshot)
+	serv.AddSnapshot("checklist", updater.ChecklistSnapshot)
+	serv.AddSnapshot("endgame", updater.EndgameSnapshot)
+	serv.AddSnapshot(TopicProcess, monitor.Snapshot)
+	serv.AddSnapshot(TopicCheckIn, checkins.Snapshot)
+	serv.AddSnapshot(TopicAlerts, alerts.Snapshot)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ytmusic", func(w http.ResponseWriter, r *http.Request) {
+		serveMediaSource(w, r, serv)
 	})
 
-	serverError := make(chan error, 1)
+	api := &API{serv: serv, updater: updater, monitor: monitor, started: time.Now()}
+	api.Register(mux)
 
-	go func() {
-		log.Printf("Server is running on http://localhost%s", *addr)
-		if err := http.ListenAndServe(*addr, nil); !errors.Is(err, http.ErrServerClosed) {
-			serverError <- err
-		}
-	}()
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		serveWs(w, r, serv, updater)
+	})
+	mux.HandleFunc("GET /events", func(w http.ResponseWriter, r *http.Request) {
+		serveEvents(w, r, serv)
+	})
 
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	// Shutdown does not wait for hijacked websocket connections, so every
+	// handler is counted here
+	var handlers sync.WaitGroup
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers.Add(1)
+			defer handlers.Done()
+			mux.ServeHTTP(w, r)
+		}),
+		// handlers watch the request context to notice the shutdown
+		BaseContext: func(net.Listener) context.Context { return ctx },
+		ConnContext: markLocalSocket,
+	}
 
-	select {
-	case err := <-serverError:
-		log.Printf("Server error: %v", err)
-	case sig := <-stop:
-		log.Printf("Received shutdown signal: %v", sig)
+	serverError := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func() {
+			serverError <- server.Serve(ln)
+		}()
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
+	var err error
+	select {
+	case err = <-serverError:
+	case <-ctx.Done():
+	}
+	cancel()
 
 	log.Println("Server is shutting down...")
-	<-ctx.Done()
-	log.Println("Server exited properly")
+
+	shutdown, stopShutdown := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer stopShutdown()
+
+	if err := server.Shutdown(shutdown); err != nil {
+		log.Println("shutdown:", err)
+	}
+	if !waitGroup(shutdown, &handlers) {
+		log.Println("shutdown: gave up waiting for connections to close")
+	}
+	background.Wait()
+	updater.Wait()
+
+	return err
+}
+
+// waitGroup waits for wg, reporting false if ctx ran out first.
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+	CheckOrigin: func(r *http.Request) bool { return auth.AllowOrigin(r) },
 }
 
-func serveWs(w http.ResponseWriter, r *http.Request, m *Monitor, s *Server) {
+// serveMediaSource relays what the YouTube Music extension reports to media
+// subscribers, and sends it toggle-playback requests.
+func serveMediaSource(w http.ResponseWriter, r *http.Request, s *Server) {
+	if !auth.AuthorizeMediaSource(w, r) {
+		return
+	}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	s.Add(conn)
-
-	defer conn.Close()
-	defer s.Remove(conn)
-
-	u := NewResinUpdater()
+	source := NewClient(conn)
+	defer func() {
+		source.Close(websocket.CloseNormalClosure, "")
+		<-source.stopped
+	}()
+	heartbeat(conn, source.Done())
 
-	go u.RunDailyNoteUpdates(conn, GenshinConfig)
-	go u.RunDailyNoteUpdates(conn, StarRailConfig)
-	go u.RunDailyNoteUpdates(conn, ZZZConfig)
+	// the connection is hijacked, so http.Server.Shutdown leaves it to us
+	stop := context.AfterFunc(r.Context(), func() {
+		source.Close(websocket.CloseGoingAway, "server shutting down")
+	})
+	defer stop()
 
-	listen := m.Register()
-	defer m.Unregister(listen)
+	s.SetMediaSource(source)
+	defer s.DropMediaSource(source)
 
-	done := make(chan struct{}, 1)
+	for {
+		mtype, b, err := conn.ReadMessage()
+		if err != nil {
+			log.Println("media source gone:", err)
+			return
+		}
+		switch mtype {
+		case websocket.TextMessage:
+			log.Println("recieved ytmusic msg: ", string(b))
+			if !json.Valid(b) {
+				continue
+			}
+			s.Broadcast(json.RawMessage(b))
+		}
+	}
+}
 
-	go func() {
+func serveWs(w http.ResponseWriter, r *http.Request, s *Server, u *ResinUpdater) {
+	scope, ok := auth.Authorize(w, r, ScopeRead)
+	if !ok {
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := writeHello(conn); err != nil {
+		log.Println(err)
+		conn.Close()
+		return
+	}
+	c := NewClient(conn)
+	s.Add(c)
+	defer func() {
+		c.Close(websocket.CloseNormalClosure, "")
+		<-c.stopped
+	}()
+	heartbeat(conn, c.Done())
+	session := &Session{serv: s, updater: u, client: c, scope: scope}
 
-		defer close(done)
+	defer conn.Close()
+	defer s.Remove(c)
 
-		for {
-			mtype, b, err := conn.ReadMessage()
-			if err != nil {
-				conn.Close()
-				break
-			}
+	// cancelled when the client leaves or the server shuts down
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
 
-			switch mtype {
-			case websocket.TextMessage:
-				log.Println("recieved ", string(b))
-				if string(b) == "toggle-playback" {
-					s.TogglePlayback()
-				}
-			}
-		}
-	}()
+	// the connection is hijacked, so http.Server.Shutdown leaves it to us
+	stop := context.AfterFunc(r.Context(), func() {
+		c.Close(websocket.CloseGoingAway, "server shutting down")
+	})
+	defer stop()
 
 	for {
-		select {
-		case <-done:
+		mtype, b, err := conn.ReadMessage()
+		if err != nil {
 			return
-		case event, ok := <-listen:
-			if !ok {
-				return
-			}
+		}
 
-			if event.Type == StopEvent {
-				log.Println("sending after stop event")
-				switch event.Name {
-				case GenshinProcess:
-					go u.RunDailyNoteUpdates(conn, GenshinConfig)
-				case StarRailProcess:
-					go u.RunDailyNoteUpdates(conn, StarRailConfig)
-				case ZZZProcess:
-					go u.RunDailyNoteUpdates(conn, ZZZConfig)
-				default:
+		switch mtype {
+		case websocket.TextMessage:
+			log.Println("recieved ", string(b))
+
+			// older media widgets send the bare command and expect no reply
+			if string(b) == "toggle-playback" {
+				if scope < ScopeControl {
+					log.Println("toggle-playback needs control access")
 					continue
 				}
+				if err := s.TogglePlayback(); err != nil {
+					log.Println(err)
+				}
+				continue
 			}
+
+			// requests run concurrently, clients match responses by id
+			go func() {
+				if err := c.Write(session.Handle(ctx, b)); err != nil {
+					log.Println(err)
+				}
+			}()
 		}
 	}
 }
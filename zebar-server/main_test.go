@@ -1,21 +1,184 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"runtime/pprof"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
 )
 
+// withNoteFixtures answers every game's note request with its fixture from
+// testdata.
+func withNoteFixtures(t *testing.T) {
+	t.Helper()
+	withTestAccount(t)
+	withCaptchaGate(t)
+
+	fixtures := map[string]string{
+		"/genshin/": "genshin_note.json",
+		"/hkrpg/":   "hkrpg_note.json",
+		"/zzz/":     "zzz_note.json",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for prefix, name := range fixtures {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				http.ServeFile(w, r, "testdata/"+name)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	oldRecord, oldZZZ := recordURL, zzzRecordURL
+	recordURL, zzzRecordURL = srv.URL, srv.URL+"/zzz"
+	t.Cleanup(func() { recordURL, zzzRecordURL = oldRecord, oldZZZ })
+}
+
 func TestMain(t *testing.T) {
+	withNoteFixtures(t)
 
-	_, err := DailyNote(ZZZConfig)
+	_, err := DailyNote(t.Context(), ZZZConfig)
 	if err != nil {
 		t.Error(err)
 	}
-	_, err = DailyNote(StarRailConfig)
+	_, err = DailyNote(t.Context(), StarRailConfig)
 	if err != nil {
 		t.Error(err)
 	}
-	_, err = DailyNote(GenshinConfig)
+	_, err = DailyNote(t.Context(), GenshinConfig)
 	if err != nil {
 		t.Error(err)
 	}
 }
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// withFakeHoyolab sends every HoYoLAB and miHoYo request, whatever its URL,
+// to a server that refuses them, so the background jobs never reach the real
+// APIs.
+func withFakeHoyolab(t *testing.T) {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"retcode":-1,"message":"fake HoYoLAB"}`))
+	}))
+	t.Cleanup(srv.Close)
+	target, _ := url.Parse(srv.URL)
+	// no idle connections, which the leak check in TestShutdown would count
+	transport := &http.Transport{DisableKeepAlives: true}
+
+	c := newHoyoClient(time.Second)
+	c.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		r = r.Clone(r.Context())
+		r.URL.Scheme, r.URL.Host, r.Host = target.Scheme, target.Host, ""
+		return transport.RoundTrip(r)
+	})
+	withHoyoClient(t, c)
+}
+
+// keeps run from touching the globals other tests use, or the network
+func withRunGlobals(t *testing.T) {
+	t.Helper()
+	withCaptchaGate(t)
+	withAlerts(t)
+	withFakeHoyolab(t)
+
+	withTestAccount(t)
+	// no accounts, so the cookie health check has nothing to refresh
+	credentials = map[Region]*Credentials{}
+}
+
+func TestShutdown(t *testing.T) {
+	withRunGlobals(t)
+
+	before := runtime.NumGoroutine()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- run(ctx, ln) }()
+
+	addr := ln.Addr().String()
+	ws, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	source, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ytmusic", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+
+	transport := &http.Transport{}
+	defer transport.CloseIdleConnections()
+	resp, err := (&http.Client{Transport: transport}).Get("http://" + addr + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	events := bufio.NewReader(resp.Body)
+	if line, _ := events.ReadString('\n'); !strings.Contains(line, `"hello"`) {
+		t.Fatalf("first event %q", line)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := ws.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(*shutdownTimeout + time.Second):
+		t.Fatal("run did not return")
+	}
+
+	for name, conn := range map[string]*websocket.Conn{"/ws": ws, "/ytmusic": source} {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Errorf("%s: err = %v, want going away", name, err)
+		}
+	}
+	for {
+		if _, err := events.ReadString('\n'); err != nil {
+			break
+		}
+	}
+
+	ws.Close()
+	source.Close()
+	resp.Body.Close()
+	transport.CloseIdleConnections()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			var stacks strings.Builder
+			pprof.Lookup("goroutine").WriteTo(&stacks, 1)
+			t.Fatalf("%d goroutines left running, %d before:\n%s", runtime.NumGoroutine(), before, stacks.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
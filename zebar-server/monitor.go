@@ -1,9 +1,13 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"fmt"
 	"log"
+	"maps"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
 
@@ -12,10 +16,6 @@ import (
 )
 
 const (
-	GenshinProcess  = "GenshinImpact.exe"
-	StarRailProcess = "StarRail.exe"
-	ZZZProcess      = "ZenlessZoneZero.exe"
-
 	StartEvent = "started"
 	StopEvent  = "stopped"
 )
@@ -27,26 +27,53 @@ type MonitorEvent struct {
 }
 
 type Monitor struct {
-	ctx       context.Context
-	cancel    context.CancelFunc
-	events    chan MonitorEvent
+	ctx    context.Context
+	cancel context.CancelFunc
+	events chan MonitorEvent
+
+	// mu guards listeners, each with a channel closed when it unregisters.
+	// It is never held across a send.
 	mu        sync.Mutex
-	listeners map[chan MonitorEvent]struct{}
+	listeners map[chan MonitorEvent]chan struct{}
+
+	// game processes currently running, by pid
+	runningMu sync.Mutex
+	running   map[string]MonitorEvent
+}
+
+type ProcessPayload struct {
+	Name    string `json:"name"`
+	Pid     string `json:"pid"`
+	Game    GameId `json:"game,omitempty"`
+	Running bool   `json:"running"`
+}
+
+func processPayload(event MonitorEvent) ProcessPayload {
+	p := ProcessPayload{Name: event.Name, Pid: event.Pid, Running: event.Type == StartEvent}
+	if game, ok := GameByProcess(event.Name); ok {
+		p.Game = game.Id()
+	}
+	return p
 }
 
 func (m *Monitor) Register() chan MonitorEvent {
 	ch := make(chan MonitorEvent, 1)
 	m.mu.Lock()
-	m.listeners[ch] = struct{}{}
+	m.listeners[ch] = make(chan struct{})
 	m.mu.Unlock()
 	return ch
 }
 
+// Unregister stops events going to ch. ch is left open, as Run may still be
+// about to send on it.
 func (m *Monitor) Unregister(ch chan MonitorEvent) {
 	m.mu.Lock()
+	done, ok := m.listeners[ch]
 	delete(m.listeners, ch)
 	m.mu.Unlock()
-	close(ch)
+	if ok {
+		close(done)
+	}
 }
 
 func NewMonitor(ctx context.Context) *Monitor {
@@ -57,7 +84,8 @@ func NewMonitor(ctx context.Context) *Monitor {
 		ctx:       ctx,
 		cancel:    cancel,
 		events:    make(chan MonitorEvent),
-		listeners: make(map[chan MonitorEvent]struct{}),
+		listeners: make(map[chan MonitorEvent]chan struct{}),
+		running:   make(map[string]MonitorEvent),
 	}
 }
 
@@ -69,25 +97,43 @@ func (m *Monitor) Run() {
 	log.Println("starting monitor")
 	ctx := m.ctx
 
-	startQuery := `
-	SELECT * FROM __InstanceCreationEvent WITHIN 2 
-	WHERE TargetInstance ISA 'Win32_Process' AND 
-	(TargetInstance.Name = 'ZenlessZoneZero.exe' OR TargetInstance.Name = 'GenshinImpact.exe' OR TargetInstance.Name = 'StarRail.exe')`
-
+	startQuery := processQuery(GameProcesses())
 	stopQuery := strings.Replace(startQuery, "__InstanceCreationEvent", "__InstanceDeletionEvent", 1)
 
-	go watchEvent(ctx, startQuery, "started", m.events)
-	go watchEvent(ctx, stopQuery, "stopped", m.events)
+	var watchers sync.WaitGroup
+	defer watchers.Wait()
+	for query, eventType := range map[string]string{startQuery: StartEvent, stopQuery: StopEvent} {
+		watchers.Add(1)
+		go func() {
+			defer watchers.Done()
+			watchEvent(ctx, query, eventType, m.events)
+		}()
+	}
 
 	for {
 		select {
 		case event := <-m.events:
 			log.Printf("Received MonitorEvent %v \n", event)
-			m.mu.Lock()
-			for l := range m.listeners {
-				l <- event
+			m.runningMu.Lock()
+			if event.Type == StartEvent {
+				m.running[event.Pid] = event
+			} else {
+				delete(m.running, event.Pid)
 			}
+			m.runningMu.Unlock()
+
+			m.mu.Lock()
+			listeners := maps.Clone(m.listeners)
 			m.mu.Unlock()
+			for l, done := range listeners {
+				select {
+				case l <- event:
+				case <-done:
+				case <-ctx.Done():
+					log.Println("Shutting down monitor")
+					return
+				}
+			}
 		case <-ctx.Done():
 			log.Println("Shutting down monitor")
 			return
@@ -95,6 +141,67 @@ func (m *Monitor) Run() {
 	}
 }
 
+// Processes lists the game processes that are running right now.
+func (m *Monitor) Processes() []ProcessPayload {
+	m.runningMu.Lock()
+	defer m.runningMu.Unlock()
+
+	processes := []ProcessPayload{}
+	for _, event := range m.running {
+		processes = append(processes, processPayload(event))
+	}
+	slices.SortFunc(processes, func(a, b ProcessPayload) int {
+		return cmp.Or(cmp.Compare(a.Name, b.Name), cmp.Compare(a.Pid, b.Pid))
+	})
+	return processes
+}
+
+func (m *Monitor) Snapshot(string) [][]byte {
+	var messages [][]byte
+	for _, p := range m.Processes() {
+		data, err := encodeEvent(TopicProcess, p)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		messages = append(messages, data)
+	}
+	return messages
+}
+
+// PublishEvents forwards process starts and stops to pub until the monitor
+// stops.
+func (m *Monitor) PublishEvents(pub Publisher) {
+	listen := m.Register()
+	defer m.Unregister(listen)
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case event := <-listen:
+			data, err := encodeEvent(TopicProcess, processPayload(event))
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			pub.Publish(TopicProcess, data)
+		}
+	}
+}
+
+func processQuery(names []string) string {
+	clauses := make([]string, len(names))
+	for i, name := range names {
+		clauses[i] = fmt.Sprintf("TargetInstance.Name = '%s'", name)
+	}
+
+	return `
+	SELECT * FROM __InstanceCreationEvent WITHIN 2 
+	WHERE TargetInstance ISA 'Win32_Process' AND 
+	(` + strings.Join(clauses, " OR ") + `)`
+}
+
 func watchEvent(ctx context.Context, query string, eventType string, events chan<- MonitorEvent) {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
@@ -150,10 +257,14 @@ func watchEvent(ctx context.Context, query string, eventType string, events chan
 			nameVal, _ := oleutil.GetProperty(instance, "Name")
 			pidVal, _ := oleutil.GetProperty(instance, "ProcessId")
 
-			events <- MonitorEvent{
+			// Run stops reading once ctx is cancelled
+			select {
+			case events <- MonitorEvent{
 				Name: nameVal.ToString(),
 				Pid:  pidVal.ToString(),
 				Type: eventType,
+			}:
+			case <-ctx.Done():
 			}
 
 			instance.Release()
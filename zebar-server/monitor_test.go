@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMonitorListenerDoesNotBlock(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	m := NewMonitor(ctx)
+	stopped := make(chan struct{})
+	go func() {
+		m.Run()
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+
+	// a listener that stops reading, like PublishEvents waiting on a
+	// subscriber that is itself waiting on Snapshot
+	stuck := m.Register()
+	for _, pid := range []string{"1", "2"} {
+		m.events <- MonitorEvent{Name: GenshinProcess, Pid: pid, Type: StartEvent}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.Processes()
+		m.Unregister(stuck)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Processes or Unregister waited on a listener")
+	}
+
+	m.events <- MonitorEvent{Name: GenshinProcess, Pid: "1", Type: StopEvent}
+	waitFor(t, "the stop event", func() bool { return len(m.Processes()) == 1 })
+}
+
+func TestMonitorStopsWhileSending(t *testing.T) {
+	m := NewMonitor(t.Context())
+	stopped := make(chan struct{})
+	go func() {
+		m.Run()
+		close(stopped)
+	}()
+
+	m.Register()
+	for _, pid := range []string{"1", "2"} {
+		m.events <- MonitorEvent{Name: GenshinProcess, Pid: pid, Type: StartEvent}
+	}
+	m.Stop()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return while a listener was full")
+	}
+}
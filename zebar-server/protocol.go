@@ -0,0 +1,106 @@
+package main
+
+//go:generate go run . protocol -schema protocol.schema.json -openapi openapi.json -ts ../test-pack/hoyoverse-daily-status/src/protocol.ts
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ProtocolVersion is announced in the hello message and bumped whenever a
+// payload changes in a way old widgets cannot handle.
+const ProtocolVersion = 3
+
+const (
+	MessageHello    = "hello"
+	MessageEvent    = "event"
+	MessageResponse = "response"
+)
+
+const (
+	TopicAlerts  = "alerts"
+	TopicCheckIn = "checkin"
+	TopicRedeem  = "redeem"
+	TopicMedia   = "media"
+	TopicProcess = "process"
+)
+
+func StaminaTopic(game GameId) string   { return "stamina." + game }
+func ChecklistTopic(game GameId) string { return "checklist." + game }
+func EndgameTopic(game GameId) string   { return "endgame." + game }
+
+// Envelope wraps every message the server sends. Seq is monotonic but not
+// contiguous: it counts every message the server creates, for any client, so
+// gaps are normal and only order can be relied on. Responses carry the id of
+// the request they answer and either a payload or an error.
+type Envelope struct {
+	Type    string          `json:"type"`
+	Topic   string          `json:"topic,omitempty"`
+	Id      string          `json:"id,omitempty"`
+	Seq     uint64          `json:"seq"`
+	Ts      int64           `json:"ts"`
+	Payload json.RawMessage `json:"payload"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// messageSeq is shared by every connection, so /events can resume from any
+// seq a client saw.
+var messageSeq atomic.Uint64
+
+func encodeMessage(kind, topic string, payload any) ([]byte, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Envelope{
+		Type:    kind,
+		Topic:   topic,
+		Seq:     messageSeq.Add(1),
+		Ts:      time.Now().UnixMilli(),
+		Payload: b,
+	})
+}
+
+func encodeEvent(topic string, payload any) ([]byte, error) {
+	return encodeMessage(MessageEvent, topic, payload)
+}
+
+func writeEvent(conn *websocket.Conn, topic string, payload any) error {
+	data, err := encodeEvent(topic, payload)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
+
+type HelloPayload struct {
+	Protocol int      `json:"protocol"`
+	Server   string   `json:"server"`
+	Topics   []string `json:"topics"`
+	Commands []string `json:"commands"`
+}
+
+func hello() HelloPayload {
+	topics := []string{TopicMedia, TopicProcess, TopicAlerts, TopicCheckIn, TopicRedeem}
+	for _, config := range Configs() {
+		topics = append(topics, StaminaTopic(config.game), ChecklistTopic(config.game), EndgameTopic(config.game))
+	}
+
+	return HelloPayload{
+		Protocol: ProtocolVersion,
+		Server:   "zbserv",
+		Topics:   topics,
+		Commands: commandNames(),
+	}
+}
+
+func writeHello(conn *websocket.Conn) error {
+	data, err := encodeMessage(MessageHello, "", hello())
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
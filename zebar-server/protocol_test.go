@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+// decodeEvent checks data is an event on topic and returns its payload.
+func decodeEvent(t *testing.T, data []byte, topic string) map[string]any {
+	t.Helper()
+
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		t.Errorf("%s: %v", data, err)
+		return nil
+	}
+	if env.Type != MessageEvent || env.Topic != topic || env.Seq == 0 || env.Ts == 0 {
+		t.Errorf("envelope = %s, want an event on %s", data, topic)
+	}
+
+	var payload map[string]any
+	if err := json.Unmarshal(env.Payload, &payload); err != nil {
+		t.Errorf("payload %s: %v", env.Payload, err)
+	}
+	return payload
+}
+
+func TestEncodeEvent(t *testing.T) {
+	first, err := encodeEvent(StaminaTopic(GENSHIN), StaminaPayload{Game: GENSHIN, Curr: 120, Max: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := encodeEvent(TopicMedia, json.RawMessage(`{"details":{"title":"song"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := decodeEvent(t, first, "stamina.genshin")
+	if payload["game"] != GENSHIN || payload["curr"] != 120.0 || payload["max"] != 200.0 {
+		t.Errorf("payload = %v", payload)
+	}
+	if media := decodeEvent(t, second, TopicMedia); media["details"] == nil {
+		t.Errorf("media payload = %v", media)
+	}
+
+	var a, b Envelope
+	json.Unmarshal(first, &a)
+	json.Unmarshal(second, &b)
+	if b.Seq <= a.Seq {
+		t.Errorf("seq went from %d to %d", a.Seq, b.Seq)
+	}
+}
+
+func TestHello(t *testing.T) {
+	data, err := encodeMessage(MessageHello, "", hello())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var env struct {
+		Envelope
+		Payload HelloPayload `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &env); err != nil {
+		t.Fatal(err)
+	}
+	if env.Type != MessageHello || env.Topic != "" {
+		t.Errorf("envelope = %s", data)
+	}
+	if env.Payload.Protocol != ProtocolVersion || len(env.Payload.Commands) == 0 {
+		t.Errorf("hello = %+v", env.Payload)
+	}
+
+	topics := map[string]bool{}
+	for _, topic := range env.Payload.Topics {
+		topics[topic] = true
+	}
+	for _, topic := range []string{TopicMedia, TopicAlerts, StaminaTopic(GENSHIN), ChecklistTopic(GENSHIN)} {
+		if !topics[topic] {
+			t.Errorf("hello is missing topic %s: %v", topic, env.Payload.Topics)
+		}
+	}
+}
+
+func TestProtocolFilesUpToDate(t *testing.T) {
+	schema, err := protocolSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	openAPI, err := openAPIDocument()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		"protocol.schema.json": schema,
+		"openapi.json":         openAPI,
+		"../test-pack/hoyoverse-daily-status/src/protocol.ts": protocolTypeScript(),
+	}
+	for path, want := range files {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s is out of date, run go generate", path)
+		}
+	}
+}
+
+func TestProtocolSchemaCoversPayloads(t *testing.T) {
+	schema, err := protocolSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		OneOf []map[string]string       `json:"oneOf"`
+		Defs  map[string]map[string]any `json:"$defs"`
+	}
+	if err := json.Unmarshal(schema, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.OneOf) != len(protocolMessages) {
+		t.Errorf("schema has %d messages, want %d", len(doc.OneOf), len(protocolMessages))
+	}
+
+	for _, name := range []string{"StaminaPayload", "TodoItem", "VerificationAlert", "CookieAlert", "CheckInResult"} {
+		if doc.Defs[name] == nil {
+			t.Errorf("schema is missing %s", name)
+		}
+	}
+
+	// embedded structs are flattened like encoding/json does
+	props := doc.Defs["VerificationAlert"]["properties"].(map[string]any)
+	if props["kind"] == nil || props["challenge"] == nil {
+		t.Errorf("VerificationAlert properties = %v", props)
+	}
+	if topicPattern(StaminaTopic("*")) != `^stamina\.[a-z0-9_]+$` {
+		t.Errorf("pattern = %s", topicPattern(StaminaTopic("*")))
+	}
+}
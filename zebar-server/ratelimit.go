@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"sync"
+	"time"
+)
+
+var (
+	rateLimit = flag.Float64("rate", 2, "HoYoLAB requests per second across all games and accounts, 0 turns the limit off")
+	rateBurst = flag.Int("burst", 6, "HoYoLAB requests allowed in a burst, at least 1")
+)
+
+// tokenBucket spaces out requests to HoYoLAB, which starts answering with
+// "too many requests" well before anything looks abusive. A rate of 0 or
+// less lets every request through.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(max(burst, 1)),
+		tokens: float64(max(burst, 1)),
+		last:   time.Now(),
+	}
+}
+
+// Wait takes a token, blocking until one is available. It reports how long
+// it had to wait.
+func (b *tokenBucket) Wait(ctx context.Context) (time.Duration, error) {
+	if b.rate <= 0 {
+		return 0, nil
+	}
+
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	// taking the token up front reserves a place in line for callers that
+	// arrive while this one is waiting
+	b.tokens--
+	if b.tokens >= 0 {
+		b.mu.Unlock()
+		return 0, nil
+	}
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return wait, nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.tokens++
+		b.mu.Unlock()
+		return 0, ctx.Err()
+	}
+}
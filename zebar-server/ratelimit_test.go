@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(20, 2)
+
+	start := time.Now()
+	for range 4 {
+		if _, err := b.Wait(t.Context()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// two tokens are available up front, the other two take 50ms each
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond || elapsed > time.Second {
+		t.Errorf("4 requests took %v, want ~100ms", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	b.Wait(ctx)
+	if _, err := b.Wait(ctx); err == nil {
+		t.Error("wait outlived its context")
+	}
+}
+
+func TestTokenBucketUnlimited(t *testing.T) {
+	for _, rate := range []float64{0, -1} {
+		b := newTokenBucket(rate, 0)
+		for range 100 {
+			if waited, err := b.Wait(t.Context()); err != nil || waited != 0 {
+				t.Fatalf("rate %v: waited %v, err %v", rate, waited, err)
+			}
+		}
+	}
+}
+
+func TestTokenBucketMinimumBurst(t *testing.T) {
+	for _, burst := range []int{0, -3} {
+		b := newTokenBucket(1, burst)
+		if waited, err := b.Wait(t.Context()); err != nil || waited != 0 {
+			t.Errorf("burst %d: first request waited %v, err %v", burst, waited, err)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RedeemSuccess = "success"
+	RedeemExpired = "expired"
+	RedeemUsed    = "already_used"
+	RedeemInvalid = "invalid"
+	RedeemFailed  = "failed"
+
+	retcodeRedeemExpired  = -2001
+	retcodeRedeemInvalid  = -2003
+	retcodeRedeemCooldown = -2016
+	retcodeRedeemInUse    = -2017
+	retcodeRedeemUsed     = -2018
+)
+
+type redeemEndpoint struct {
+	url     string
+	gameBiz string
+}
+
+func redeemEndpointFor(id GameId) (redeemEndpoint, bool) {
+	game, ok := GameById(id)
+	if !ok {
+		return redeemEndpoint{}, false
+	}
+	return game.RedeemEndpoint()
+}
+
+// HoYoverse rejects a redemption made within 5 seconds of the previous one
+// on the same cookie.
+var redeemCooldown = 6 * time.Second
+
+var (
+	redeemMu   sync.Mutex
+	lastRedeem time.Time
+)
+
+type RedeemResult struct {
+	Game    GameId `json:"game"`
+	Uid     string `json:"uid"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+type redeemResponse struct {
+	Retcode int    `json:"retcode"`
+	Message string `json:"message"`
+}
+
+func redeemStatus(retcode int) string {
+	switch retcode {
+	case 0:
+		return RedeemSuccess
+	case retcodeRedeemExpired:
+		return RedeemExpired
+	case retcodeRedeemInUse, retcodeRedeemUsed:
+		return RedeemUsed
+	case retcodeRedeemInvalid:
+		return RedeemInvalid
+	default:
+		return RedeemFailed
+	}
+}
+
+func accountsFor(game GameId) []GameConfig {
+	var accounts []GameConfig
+	for _, config := range Configs() {
+		if config.game == game {
+			accounts = append(accounts, config)
+		}
+	}
+	return accounts
+}
+
+func RedeemCodeForGame(ctx context.Context, game GameId, code string) ([]RedeemResult, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil, errors.New("empty redemption code")
+	}
+	if _, ok := redeemEndpointFor(game); !ok {
+		return nil, fmt.Errorf("redemption is not supported for %s", game)
+	}
+
+	// miHoYo has no web redemption page for CN accounts
+	var accounts []GameConfig
+	for _, config := range accountsFor(game) {
+		if config.Region() != RegionChina {
+			accounts = append(accounts, config)
+		}
+	}
+	if len(accounts) == 0 {
+		return nil, fmt.Errorf("no accounts configured for %s", game)
+	}
+
+	return RedeemCode(ctx, accounts, code), nil
+}
+
+func RedeemCode(ctx context.Context, accounts []GameConfig, code string) []RedeemResult {
+	results := make([]RedeemResult, 0, len(accounts))
+
+	for _, config := range accounts {
+		result := RedeemResult{Game: config.game, Uid: config.uid}
+
+		resp, err := redeem(ctx, config, code)
+		if err == nil && resp.Retcode == retcodeRedeemCooldown {
+			resp, err = redeem(ctx, config, code)
+		}
+
+		if err != nil {
+			result.Status = RedeemFailed
+			result.Message = redactError(err)
+		} else {
+			result.Status = redeemStatus(resp.Retcode)
+			result.Message = resp.Message
+		}
+
+		log.Println("redeemed", code, "for", config.game, config.uid, result.Status)
+		results = append(results, result)
+	}
+
+	return results
+}
+
+func waitRedeemCooldown(ctx context.Context) error {
+	if wait := redeemCooldown - time.Since(lastRedeem); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return nil
+}
+
+func redeem(ctx context.Context, config GameConfig, code string) (redeemResponse, error) {
+	endpoint, _ := redeemEndpointFor(config.game)
+
+	query := url.Values{}
+	query.Set("uid", config.uid)
+	query.Set("region", config.server)
+	query.Set("lang", "en")
+	query.Set("cdkey", code)
+	query.Set("game_biz", endpoint.gameBiz)
+	query.Set("sLangKey", "en-us")
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint.url+"?"+query.Encode(), nil)
+	if err != nil {
+		return redeemResponse{}, err
+	}
+	req.Header.Set("Cookie", config.Cookie())
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Referer", "https://hoyoverse.com/")
+
+	redeemMu.Lock()
+	if err := waitRedeemCooldown(ctx); err != nil {
+		redeemMu.Unlock()
+		return redeemResponse{}, err
+	}
+	_, bytes, err := hoyo.Do(req)
+	// counted from the response so a slow request does not eat into the
+	// cooldown of the next one
+	lastRedeem = time.Now()
+	redeemMu.Unlock()
+	if err != nil {
+		return redeemResponse{}, err
+	}
+
+	var result redeemResponse
+	err = json.Unmarshal(bytes, &result)
+	return result, err
+}
+
+type RedeemPayload struct {
+	Game    GameId         `json:"game"`
+	Code    string         `json:"code"`
+	Results []RedeemResult `json:"results"`
+}
+
+func runRedeemCommand(args []string) int {
+	fs := flag.NewFlagSet("redeem", flag.ExitOnError)
+	game := fs.String("game", "", "game to redeem the code for (genshin, hkrpg, zzz)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: zbserv redeem -game <game> <code>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *game == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	failed := false
+	for _, code := range fs.Args() {
+		results, err := RedeemCodeForGame(context.Background(), GameId(*game), code)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, result := range results {
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", code, result.Game, result.Uid, result.Status, result.Message)
+			if result.Status != RedeemSuccess {
+				failed = true
+			}
+		}
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func withFakeRedeem(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	withTestAccount(t)
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	var games []Game
+	for _, game := range Games() {
+		games = append(games, fakeRedeemGame{Game: game, url: srv.URL})
+	}
+	withGames(t, games...)
+
+	oldCooldown := redeemCooldown
+	redeemCooldown = 50 * time.Millisecond
+	t.Cleanup(func() { redeemCooldown = oldCooldown })
+}
+
+// fakeRedeemGame sends game's redemptions to url.
+type fakeRedeemGame struct {
+	Game
+	url string
+}
+
+func (g fakeRedeemGame) RedeemEndpoint() (redeemEndpoint, bool) {
+	endpoint, ok := g.Game.RedeemEndpoint()
+	endpoint.url = g.url
+	return endpoint, ok
+}
+
+func TestRedeemCode(t *testing.T) {
+	retcodes := map[string]int{
+		"1": 0,
+		"2": retcodeRedeemUsed,
+		"3": retcodeRedeemExpired,
+		"4": retcodeRedeemInvalid,
+		"5": -1071,
+	}
+
+	var (
+		mu    sync.Mutex
+		times []time.Time
+	)
+	withFakeRedeem(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+
+		q := r.URL.Query()
+		if q.Get("cdkey") != "GENSHINGIFT" || q.Get("game_biz") != "hk4e_global" || q.Get("region") != "os_usa" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		fmt.Fprintf(w, `{"retcode":%d,"message":"msg %s"}`, retcodes[q.Get("uid")], q.Get("uid"))
+	})
+
+	var accounts []GameConfig
+	for _, uid := range []string{"1", "2", "3", "4", "5"} {
+		config := GenshinConfig
+		config.uid = uid
+		accounts = append(accounts, config)
+	}
+
+	results := RedeemCode(t.Context(), accounts, "GENSHINGIFT")
+
+	want := []string{RedeemSuccess, RedeemUsed, RedeemExpired, RedeemInvalid, RedeemFailed}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, result := range results {
+		if result.Status != want[i] || result.Uid != accounts[i].uid {
+			t.Errorf("result %d = %+v, want status %s", i, result, want[i])
+		}
+	}
+
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap < redeemCooldown {
+			t.Errorf("request %d sent %s after the previous one, want at least %s", i, gap, redeemCooldown)
+		}
+	}
+}
+
+func TestRedeemCodeRetriesCooldown(t *testing.T) {
+	calls := 0
+	withFakeRedeem(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			fmt.Fprintf(w, `{"retcode":%d,"message":"Redemption in cooldown"}`, retcodeRedeemCooldown)
+			return
+		}
+		fmt.Fprint(w, `{"retcode":0,"message":"Redeemed successfully"}`)
+	})
+
+	results := RedeemCode(t.Context(), []GameConfig{ZZZConfig}, "ZZZCODE")
+	if len(results) != 1 || results[0].Status != RedeemSuccess {
+		t.Errorf("results = %+v", results)
+	}
+	if calls != 2 {
+		t.Errorf("calls = %d, want 2", calls)
+	}
+}
+
+func TestRedeemCodeForGameRejectsUnknownGame(t *testing.T) {
+	if _, err := RedeemCodeForGame(t.Context(), "bh3", "CODE"); err == nil {
+		t.Error("expected error for unsupported game")
+	}
+	if _, err := RedeemCodeForGame(t.Context(), GENSHIN, "  "); err == nil {
+		t.Error("expected error for empty code")
+	}
+}
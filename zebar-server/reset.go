@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	resetHour = 4
+
+	// HoYoLAB lags slightly behind the in-game reset, refetching right on
+	// the hour still returns yesterday's progress.
+	resetGrace = time.Minute
+)
+
+type ResetTimes struct {
+	Game   GameId `json:"game"`
+	Server string `json:"server"`
+	Daily  int64  `json:"daily"`
+	Weekly int64  `json:"weekly"`
+}
+
+// serverOffset looks server up among every game's servers.
+func serverOffset(server string) (int, bool) {
+	for _, game := range Games() {
+		if offset, ok := game.Servers()[server]; ok {
+			return offset, true
+		}
+	}
+	return 0, false
+}
+
+func ServerLocation(server string) *time.Location {
+	offset, ok := serverOffset(server)
+	if !ok {
+		offset = 8
+	}
+	return time.FixedZone(server, offset*60*60)
+}
+
+func NextDailyReset(server string, now time.Time) time.Time {
+	t := now.In(ServerLocation(server))
+
+	reset := time.Date(t.Year(), t.Month(), t.Day(), resetHour, 0, 0, 0, t.Location())
+	if !reset.After(t) {
+		reset = reset.AddDate(0, 0, 1)
+	}
+	return reset
+}
+
+func NextWeeklyReset(server string, now time.Time) time.Time {
+	t := now.In(ServerLocation(server))
+
+	reset := time.Date(t.Year(), t.Month(), t.Day(), resetHour, 0, 0, 0, t.Location())
+	reset = reset.AddDate(0, 0, (int(time.Monday)-int(reset.Weekday())+7)%7)
+	if !reset.After(t) {
+		reset = reset.AddDate(0, 0, 7)
+	}
+	return reset
+}
+
+func Resets(config GameConfig, now time.Time) ResetTimes {
+	return ResetTimes{
+		Game:   config.game,
+		Server: config.server,
+		Daily:  NextDailyReset(config.server, now).Unix(),
+		Weekly: NextWeeklyReset(config.server, now).Unix(),
+	}
+}
+
+func applyResets(items []TodoItem, server string, now time.Time) {
+	for i := range items {
+		if items[i].ResetsAt != 0 {
+			continue
+		}
+		switch items[i].Reset {
+		case ResetDaily:
+			items[i].ResetsAt = NextDailyReset(server, now).Unix()
+		case ResetWeekly:
+			items[i].ResetsAt = NextWeeklyReset(server, now).Unix()
+		}
+	}
+}
+
+// the weekly reset and endgame rotations land on a daily one, so watching
+// daily resets covers all of them
+func (u *ResinUpdater) RunResetRefresh(ctx context.Context, config GameConfig) {
+	for {
+		next := NextDailyReset(config.server, time.Now()).Add(resetGrace)
+		timer := time.NewTimer(time.Until(next))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			log.Println("daily reset passed for", config.game, "refetching note")
+			if err := u.RunDailyNoteUpdates(ctx, config); err != nil {
+				log.Println(err)
+			}
+			if err := u.RunEndgameUpdates(ctx, config); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+func serveResets(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+
+	resets := []ResetTimes{}
+	for _, config := range Configs() {
+		resets = append(resets, Resets(config, now))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resets); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextDailyReset(t *testing.T) {
+	tests := []struct {
+		server string
+		now    string
+		want   string
+	}{
+		{"os_usa", "2025-06-16T08:59:00Z", "2025-06-16T09:00:00Z"},
+		{"os_usa", "2025-06-16T09:00:00Z", "2025-06-17T09:00:00Z"},
+		{"prod_gf_us", "2025-06-16T02:00:00Z", "2025-06-16T09:00:00Z"},
+		{"os_euro", "2025-06-16T02:59:59Z", "2025-06-16T03:00:00Z"},
+		{"os_asia", "2025-06-16T21:00:00Z", "2025-06-17T20:00:00Z"},
+		{"prod_official_asia", "2025-06-16T19:30:00Z", "2025-06-16T20:00:00Z"},
+		{"glb_prod_wd01", "2025-06-16T20:00:00Z", "2025-06-17T20:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		now, _ := time.Parse(time.RFC3339, tt.now)
+		want, _ := time.Parse(time.RFC3339, tt.want)
+
+		if got := NextDailyReset(tt.server, now); !got.Equal(want) {
+			t.Errorf("NextDailyReset(%s, %s) = %s, want %s", tt.server, tt.now, got.UTC(), tt.want)
+		}
+	}
+}
+
+// unknown servers quietly fall back to UTC+8
+func TestDefaultServersHaveOffsets(t *testing.T) {
+	for _, game := range Games() {
+		server := game.Account().server
+		if _, ok := game.Servers()[server]; !ok {
+			t.Errorf("%s server %s has no offset", game.Id(), server)
+		}
+	}
+}
+
+func TestNextWeeklyReset(t *testing.T) {
+	tests := []struct {
+		server string
+		now    string
+		want   string
+	}{
+		// 2025-06-16 is a Monday
+		{"os_usa", "2025-06-16T08:00:00Z", "2025-06-16T09:00:00Z"},
+		{"os_usa", "2025-06-16T09:00:00Z", "2025-06-23T09:00:00Z"},
+		{"os_usa", "2025-06-21T12:00:00Z", "2025-06-23T09:00:00Z"},
+		// Monday 01:00 UTC is still Sunday evening in America
+		{"os_usa", "2025-06-16T01:00:00Z", "2025-06-16T09:00:00Z"},
+		{"os_asia", "2025-06-15T19:00:00Z", "2025-06-15T20:00:00Z"},
+		{"os_euro", "2025-06-22T03:30:00Z", "2025-06-23T03:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		now, _ := time.Parse(time.RFC3339, tt.now)
+		want, _ := time.Parse(time.RFC3339, tt.want)
+
+		if got := NextWeeklyReset(tt.server, now); !got.Equal(want) {
+			t.Errorf("NextWeeklyReset(%s, %s) = %s, want %s", tt.server, tt.now, got.UTC(), tt.want)
+		}
+	}
+}
+
+func TestApplyResets(t *testing.T) {
+	now, _ := time.Parse(time.RFC3339, "2025-06-18T12:00:00Z")
+
+	items := []TodoItem{
+		newTodo(GENSHIN, "commissions", "Daily Commissions", 0, 4, ResetDaily),
+		newTodo(GENSHIN, "weekly_bosses", "Weekly Bosses", 0, 3, ResetWeekly),
+		{Id: "zzz.ridu_weekly", Reset: ResetWeekly, ResetsAt: 42},
+	}
+	applyResets(items, "os_usa", now)
+
+	if want := NextDailyReset("os_usa", now).Unix(); items[0].ResetsAt != want {
+		t.Errorf("daily ResetsAt = %d, want %d", items[0].ResetsAt, want)
+	}
+	if want := NextWeeklyReset("os_usa", now).Unix(); items[1].ResetsAt != want {
+		t.Errorf("weekly ResetsAt = %d, want %d", items[1].ResetsAt, want)
+	}
+	if items[2].ResetsAt != 42 {
+		t.Errorf("ResetsAt from the API was overwritten: %d", items[2].ResetsAt)
+	}
+}
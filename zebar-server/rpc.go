@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// error codes follow JSON-RPC 2.0
+const (
+	ErrCodeParse          = -32700
+	ErrCodeInvalidRequest = -32600
+	ErrCodeUnknownMethod  = -32601
+	ErrCodeInvalidParams  = -32602
+	ErrCodeFailed         = -32000
+	ErrCodeForbidden      = -32001
+)
+
+// Request is a command sent by a client. The id is echoed in the response so
+// the client can match them up.
+type Request struct {
+	Id     string          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+func invalidParams(format string, args ...any) *RPCError {
+	return &RPCError{Code: ErrCodeInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+// Session is what a command can act on: the server and the client that sent
+// it, along with what the client is allowed to do.
+type Session struct {
+	serv    *Server
+	updater *ResinUpdater
+	client  *Client
+	scope   Scope
+}
+
+type Handler func(ctx context.Context, s *Session, params json.RawMessage) (any, error)
+
+// Command is a registered method and the scope a client needs to call it.
+// The params type is kept for generating the widget's TypeScript types.
+type Command struct {
+	scope  Scope
+	params reflect.Type
+	run    Handler
+}
+
+// command adapts a handler taking typed params. Unknown fields are rejected
+// so typos do not silently fall back to zero values.
+func command[P any](scope Scope, fn func(ctx context.Context, s *Session, params P) (any, error)) Command {
+	return Command{scope: scope, params: reflect.TypeFor[P](), run: func(ctx context.Context, s *Session, raw json.RawMessage) (any, error) {
+		var params P
+		if len(raw) > 0 && string(raw) != "null" {
+			dec := json.NewDecoder(bytes.NewReader(raw))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&params); err != nil {
+				return nil, invalidParams("%v", err)
+			}
+		}
+		return fn(ctx, s, params)
+	}}
+}
+
+var commands = map[string]Command{
+	"subscribe":     command(ScopeRead, subscribeCommand),
+	"unsubscribe":   command(ScopeRead, unsubscribeCommand),
+	"media.toggle":  command(ScopeControl, mediaToggleCommand),
+	"game.refresh":  command(ScopeControl, refreshCommand),
+	"redeem":        command(ScopeControl, redeemCommand),
+	"captcha.solve": command(ScopeControl, captchaSolveCommand),
+	"alert.ack":     command(ScopeControl, alertAckCommand),
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func encodeResponse(id string, result any, err error) ([]byte, error) {
+	env := Envelope{
+		Type: MessageResponse,
+		Id:   id,
+		Seq:  messageSeq.Add(1),
+		Ts:   time.Now().UnixMilli(),
+	}
+
+	if err != nil {
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &RPCError{Code: ErrCodeFailed, Message: redactError(err)}
+		}
+		env.Error = rpcErr
+		env.Payload = json.RawMessage("null")
+	} else {
+		b, err := json.Marshal(result)
+		if err != nil {
+			return nil, err
+		}
+		env.Payload = b
+	}
+
+	return json.Marshal(env)
+}
+
+// Handle runs one request and returns the response to send back.
+func (s *Session) Handle(ctx context.Context, data []byte) []byte {
+	var req Request
+	var result any
+	var err error
+
+	if jsonErr := json.Unmarshal(data, &req); jsonErr != nil {
+		err = &RPCError{Code: ErrCodeParse, Message: jsonErr.Error()}
+	} else if req.Id == "" || req.Method == "" {
+		err = &RPCError{Code: ErrCodeInvalidRequest, Message: "requests need an id and a method"}
+	} else if cmd, ok := commands[req.Method]; !ok {
+		err = &RPCError{Code: ErrCodeUnknownMethod, Message: "unknown method " + req.Method}
+	} else if s.scope < cmd.scope {
+		err = &RPCError{Code: ErrCodeForbidden, Message: req.Method + " needs " + cmd.scope.String() + " access"}
+	} else {
+		result, err = cmd.run(ctx, s, req.Params)
+	}
+
+	if err != nil {
+		log.Println(req.Method, "failed:", redactError(err))
+	}
+
+	resp, encErr := encodeResponse(req.Id, result, err)
+	if encErr != nil {
+		log.Println(encErr)
+		resp, _ = encodeResponse(req.Id, nil, encErr)
+	}
+	return resp
+}
+
+// accountsParam resolves the game a command is about to the accounts
+// configured for it.
+func accountsParam(game GameId) ([]GameConfig, error) {
+	if game == "" {
+		return nil, invalidParams("game is required")
+	}
+	accounts := accountsFor(game)
+	if len(accounts) == 0 {
+		return nil, invalidParams("no accounts configured for %s", game)
+	}
+	return accounts, nil
+}
+
+type TopicsParams struct {
+	Topics []string `json:"topics"`
+}
+
+func subscribeCommand(ctx context.Context, s *Session, p TopicsParams) (any, error) {
+	return nil, s.serv.Subscribe(s.client, p.Topics)
+}
+
+func unsubscribeCommand(ctx context.Context, s *Session, p TopicsParams) (any, error) {
+	s.serv.Unsubscribe(s.client, p.Topics)
+	return nil, nil
+}
+
+func mediaToggleCommand(ctx context.Context, s *Session, _ struct{}) (any, error) {
+	return nil, s.serv.TogglePlayback()
+}
+
+type GameParams struct {
+	Game GameId `json:"game"`
+}
+
+// refreshCommand fetches the game's accounts again right away, retrying any
+// that were paused for a captcha. Cached records are skipped, they may be
+// from before the captcha was solved.
+func refreshCommand(ctx context.Context, s *Session, p GameParams) (any, error) {
+	accounts, err := accountsParam(p.Game)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, config := range accounts {
+		captchas.Retry(config)
+		records.Invalidate(recordAccount(config))
+		if err := s.updater.RunDailyNoteUpdates(ctx, config); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+type RedeemParams struct {
+	Game GameId `json:"game"`
+	Code string `json:"code"`
+}
+
+// redeemCommand answers with the per-account results and also publishes
+// them, so other widgets showing redemptions see the outcome.
+func redeemCommand(ctx context.Context, s *Session, p RedeemParams) (any, error) {
+	if _, err := accountsParam(p.Game); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(p.Code) == "" {
+		return nil, invalidParams("code is required")
+	}
+
+	results, err := RedeemCodeForGame(ctx, p.Game, p.Code)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := RedeemPayload{Game: p.Game, Code: p.Code, Results: results}
+	if data, err := encodeEvent(TopicRedeem, payload); err == nil {
+		s.serv.Publish(TopicRedeem, data)
+	}
+	return payload, nil
+}
+
+// CaptchaSolveParams carries the geetest result. Without one the user solved
+// the captcha on the HoYoLAB site and the account is simply fetched again.
+type CaptchaSolveParams struct {
+	Game      GameId `json:"game"`
+	Challenge string `json:"challenge,omitempty"`
+	Validate  string `json:"validate,omitempty"`
+	Seccode   string `json:"seccode,omitempty"`
+}
+
+func captchaSolveCommand(ctx context.Context, s *Session, p CaptchaSolveParams) (any, error) {
+	accounts, err := accountsParam(p.Game)
+	if err != nil {
+		return nil, err
+	}
+	if p.Validate != "" && p.Challenge == "" {
+		return nil, invalidParams("challenge is required with validate")
+	}
+
+	var errs []error
+	for _, config := range accounts {
+		if err := captchas.Solved(ctx, config, p.Challenge, p.Validate, p.Seccode); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if p.Validate == "" {
+			// only a successful fetch shows it was really solved
+			records.Invalidate(recordAccount(config))
+			if err := s.updater.RunDailyNoteUpdates(ctx, config); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+type AlertAckParams struct {
+	Id string `json:"id"`
+}
+
+func alertAckCommand(ctx context.Context, s *Session, p AlertAckParams) (any, error) {
+	if p.Id == "" {
+		return nil, invalidParams("id is required")
+	}
+	if !alerts.Ack(p.Id) {
+		return nil, invalidParams("no standing alert %s", p.Id)
+	}
+	return nil, nil
+}
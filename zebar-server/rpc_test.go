@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func withAlerts(t *testing.T) {
+	t.Helper()
+
+	old := alerts
+	alerts = NewAlerts()
+	t.Cleanup(func() { alerts = old })
+}
+
+type response struct {
+	Envelope
+	Payload json.RawMessage `json:"payload"`
+}
+
+func call(t *testing.T, s *Session, request string) response {
+	t.Helper()
+
+	data := s.Handle(t.Context(), []byte(request))
+
+	var resp response
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatalf("%s: %v", data, err)
+	}
+	if resp.Type != MessageResponse || resp.Seq == 0 || resp.Ts == 0 {
+		t.Errorf("envelope = %s, want a response", data)
+	}
+	return resp
+}
+
+func TestHandleErrors(t *testing.T) {
+	serv := NewServer()
+	client, _ := dialServer(t, serv)
+	s := &Session{serv: serv, client: client, scope: ScopeControl}
+
+	tests := []struct {
+		request string
+		id      string
+		code    int
+	}{
+		{`{"id":`, "", ErrCodeParse},
+		{`{"method":"subscribe"}`, "", ErrCodeInvalidRequest},
+		{`{"id":"1","method":"rewind"}`, "1", ErrCodeUnknownMethod},
+		{`{"id":"2","method":"subscribe","params":{"topic":"media"}}`, "2", ErrCodeInvalidParams},
+		{`{"id":"3","method":"subscribe","params":{"topics":["weather"]}}`, "3", ErrCodeInvalidParams},
+		{`{"id":"4","method":"game.refresh","params":{"game":"wuwa"}}`, "4", ErrCodeInvalidParams},
+		{`{"id":"5","method":"redeem","params":{"game":"genshin","code":" "}}`, "5", ErrCodeInvalidParams},
+		{`{"id":"6","method":"alert.ack","params":{"id":"cookie:os"}}`, "6", ErrCodeInvalidParams},
+		{`{"id":"7","method":"media.toggle"}`, "7", ErrCodeFailed},
+	}
+
+	for _, tt := range tests {
+		resp := call(t, s, tt.request)
+		if resp.Id != tt.id || resp.Error == nil || resp.Error.Code != tt.code {
+			t.Errorf("%s: id %q, error %+v, want code %d", tt.request, resp.Id, resp.Error, tt.code)
+		}
+		if string(resp.Payload) != "null" {
+			t.Errorf("%s: payload %s on error", tt.request, resp.Payload)
+		}
+	}
+}
+
+func TestSubscribeCommand(t *testing.T) {
+	serv := NewServer()
+	client, _ := dialServer(t, serv)
+	s := &Session{serv: serv, client: client, scope: ScopeControl}
+
+	resp := call(t, s, `{"id":"sub","method":"subscribe","params":{"topics":["stamina","alerts"]}}`)
+	if resp.Error != nil || resp.Id != "sub" {
+		t.Fatalf("response = %+v", resp)
+	}
+	if !client.Subscribed(StaminaTopic(GENSHIN)) || !client.Subscribed(TopicAlerts) {
+		t.Error("subscribe command did not subscribe")
+	}
+
+	call(t, s, `{"id":"unsub","method":"unsubscribe","params":{"topics":["alerts"]}}`)
+	if client.Subscribed(TopicAlerts) {
+		t.Error("unsubscribe command did not unsubscribe")
+	}
+}
+
+func TestRefreshCommand(t *testing.T) {
+	calls := withCountingRecords(t, 0)
+	withRecordGroup(t, newRecordGroup(time.Minute))
+	withCaptchaGate(t)
+
+	// an explicit refresh goes past the cache
+	if _, err := DailyNote(t.Context(), GenshinConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	serv := NewServer()
+	client, conn := dialServer(t, serv)
+	serv.Subscribe(client, []string{StaminaTopic(GENSHIN)})
+
+	s := &Session{serv: serv, updater: NewResinUpdater(t.Context(), serv), client: client, scope: ScopeControl}
+	resp := call(t, s, `{"id":"r","method":"game.refresh","params":{"game":"genshin"}}`)
+	if resp.Error != nil {
+		t.Fatalf("error = %+v", resp.Error)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("fetched %d times, want 2", calls.Load())
+	}
+	if msg := readEvent(t, conn, StaminaTopic(GENSHIN)); msg["curr"] != 112.0 {
+		t.Errorf("stamina = %v", msg)
+	}
+}
+
+func TestAlertAck(t *testing.T) {
+	withAlerts(t)
+	creds := NewCredentials(RegionOverseas, "ltoken_v2=stale", "", "")
+	published := withFakeAccount(t, &fakeAccount{}, creds)
+	alerts.SetPublisher(creds.publish)
+
+	creds.setAlert(AlertCookieInvalid, "rejected")
+	if n := len(alerts.Snapshot(TopicAlerts)); n != 1 {
+		t.Fatalf("%d standing alerts, want 1", n)
+	}
+
+	s := &Session{serv: NewServer(), scope: ScopeControl}
+	if resp := call(t, s, `{"id":"ack","method":"alert.ack","params":{"id":"cookie:os"}}`); resp.Error != nil {
+		t.Fatalf("error = %+v", resp.Error)
+	}
+
+	if n := len(alerts.Snapshot(TopicAlerts)); n != 0 {
+		t.Errorf("%d alerts replayed after acknowledging", n)
+	}
+	last := (*published)[len(*published)-1]
+	if last["kind"] != AlertAcknowledged || last["id"] != "cookie:os" {
+		t.Errorf("published %v", last)
+	}
+
+	// a different alert for the same cookie shows up again
+	creds.setAlert(AlertCookieExpiring, "expiring")
+	if n := len(alerts.Snapshot(TopicAlerts)); n != 1 {
+		t.Errorf("%d standing alerts after the alert changed, want 1", n)
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// protocolMessage describes one kind of server message. The JSON Schema and
+// the widget's TypeScript types are generated from this table, run
+// `go generate` after changing it or any payload type.
+type protocolMessage struct {
+	name  string
+	kind  string
+	topic string // a "*" stands for any game id
+	// more than one payload means any one of them
+	payloads []any
+}
+
+var protocolMessages = []protocolMessage{
+	{name: "Hello", kind: MessageHello, payloads: []any{HelloPayload{}}},
+	{name: "Stamina", kind: MessageEvent, topic: StaminaTopic("*"), payloads: []any{StaminaPayload{}}},
+	{name: "Checklist", kind: MessageEvent, topic: ChecklistTopic("*"), payloads: []any{ChecklistPayload{}}},
+	{name: "Endgame", kind: MessageEvent, topic: EndgameTopic("*"), payloads: []any{EndgamePayload{}}},
+	{name: "CheckIn", kind: MessageEvent, topic: TopicCheckIn, payloads: []any{CheckInResult{}}},
+	{name: "Redeem", kind: MessageEvent, topic: TopicRedeem, payloads: []any{RedeemPayload{}}},
+	{name: "Process", kind: MessageEvent, topic: TopicProcess, payloads: []any{ProcessPayload{}}},
+	{name: "Alert", kind: MessageEvent, topic: TopicAlerts, payloads: []any{VerificationAlert{}, CookieAlert{}, AlertAck{}}},
+	// relayed as is from the YouTube Music extension, until it disconnects
+	{name: "Media", kind: MessageEvent, topic: TopicMedia, payloads: []any{json.RawMessage{}, MediaSourceStatus{}}},
+	// the result of whichever command the id belongs to, null on error
+	{name: "Response", kind: MessageResponse, payloads: []any{json.RawMessage{}}},
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+type jsonField struct {
+	name     string
+	typ      reflect.Type
+	optional bool
+}
+
+// jsonFields lists the fields of t the way encoding/json writes them.
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			fields = append(fields, jsonFields(f.Type)...)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, jsonField{
+			name:     name,
+			typ:      f.Type,
+			optional: strings.Contains(opts, "omitempty") || f.Type.Kind() == reflect.Pointer,
+		})
+	}
+	return fields
+}
+
+// topicPattern turns a topic with a game wildcard into a regexp.
+func topicPattern(topic string) string {
+	return "^" + strings.ReplaceAll(regexp.QuoteMeta(topic), `\*`, "[a-z0-9_]+") + "$"
+}
+
+type schemaGen struct {
+	defs map[string]any
+	// where defs end up in the document, "#/$defs/" unless set
+	ref string
+}
+
+func (g *schemaGen) schema(t reflect.Type) any {
+	if t == rawMessageType {
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice:
+		// nil slices and maps encode as null
+		return map[string]any{"type": []string{"array", "null"}, "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": []string{"object", "null"}, "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := g.defs[name]; !ok {
+			g.defs[name] = nil
+
+			properties := map[string]any{}
+			required := []string{}
+			for _, f := range jsonFields(t) {
+				properties[f.name] = g.schema(f.typ)
+				if !f.optional {
+					required = append(required, f.name)
+				}
+			}
+			g.defs[name] = map[string]any{
+				"type":       "object",
+				"properties": properties,
+				"required":   required,
+			}
+		}
+		ref := g.ref
+		if ref == "" {
+			ref = "#/$defs/"
+		}
+		return map[string]any{"$ref": ref + name}
+	}
+	panic(fmt.Sprintf("no schema for %s", t))
+}
+
+func (g *schemaGen) payload(payloads []any) any {
+	if len(payloads) == 1 {
+		return g.schema(reflect.TypeOf(payloads[0]))
+	}
+	var oneOf []any
+	for _, p := range payloads {
+		oneOf = append(oneOf, g.schema(reflect.TypeOf(p)))
+	}
+	return map[string]any{"oneOf": oneOf}
+}
+
+// seqDescription documents Envelope.Seq in the schema and the TypeScript
+// types.
+const seqDescription = "monotonic, not contiguous: messages sent to other clients take seqs too"
+
+// protocolSchema is a JSON Schema matching every message the server sends.
+func protocolSchema() ([]byte, error) {
+	g := &schemaGen{defs: map[string]any{}}
+
+	var messages []any
+	for _, m := range protocolMessages {
+		properties := map[string]any{
+			"type":    map[string]any{"const": m.kind},
+			"seq":     map[string]any{"type": "integer", "minimum": 1, "description": seqDescription},
+			"ts":      map[string]any{"type": "integer", "description": "unix milliseconds"},
+			"payload": g.payload(m.payloads),
+		}
+		required := []string{"type", "seq", "ts", "payload"}
+
+		switch {
+		case strings.Contains(m.topic, "*"):
+			properties["topic"] = map[string]any{"type": "string", "pattern": topicPattern(m.topic)}
+		case m.topic != "":
+			properties["topic"] = map[string]any{"const": m.topic}
+		}
+		if m.topic != "" {
+			required = append(required, "topic")
+		}
+		if m.kind == MessageResponse {
+			properties["id"] = map[string]any{"type": "string"}
+			properties["error"] = g.schema(reflect.TypeOf(RPCError{}))
+			required = append(required, "id")
+		}
+
+		g.defs[m.name+"Message"] = map[string]any{
+			"type":       "object",
+			"properties": properties,
+			"required":   required,
+		}
+		messages = append(messages, map[string]any{"$ref": "#/$defs/" + m.name + "Message"})
+	}
+
+	b, err := json.MarshalIndent(map[string]any{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"title":       "zbserv server message",
+		"description": fmt.Sprintf("protocol version %d", ProtocolVersion),
+		"oneOf":       messages,
+		"$defs":       g.defs,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+var pathParam = regexp.MustCompile(`\{(\w+)\}`)
+
+// openAPIDocument describes the HTTP API in apiRoutes.
+func openAPIDocument() ([]byte, error) {
+	g := &schemaGen{defs: map[string]any{}, ref: "#/components/schemas/"}
+	errorBody := map[string]any{"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(ErrorBody{}))}}
+
+	paths := map[string]map[string]any{}
+	for _, route := range apiRoutes {
+		responses := map[string]any{
+			"200": map[string]any{
+				"description": "OK",
+				"content":     map[string]any{"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(route.response))}},
+			},
+			"401": map[string]any{"description": "No API key, or an unknown one", "content": errorBody},
+			"403": map[string]any{"description": "The origin is not allowed", "content": errorBody},
+		}
+		for status, description := range route.statuses {
+			response := map[string]any{"description": description}
+			if status >= 400 {
+				response["content"] = errorBody
+			}
+			responses[fmt.Sprint(status)] = response
+		}
+
+		op := map[string]any{"summary": route.summary, "responses": responses}
+		var params []any
+		for _, m := range pathParam.FindAllStringSubmatch(route.path, -1) {
+			params = append(params, map[string]any{
+				"name":     m[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+
+		if paths[route.path] == nil {
+			paths[route.path] = map[string]any{}
+		}
+		paths[route.path][strings.ToLower(route.method)] = op
+	}
+
+	b, err := json.MarshalIndent(map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":       "zbserv",
+			"version":     fmt.Sprint(ProtocolVersion),
+			"description": "Read-only view of the state zbserv pushes over the websocket.",
+		},
+		"paths": paths,
+		// only enforced once API keys are configured
+		"security": []any{map[string]any{"bearer": []string{}}, map[string]any{"key": []string{}}},
+		"components": map[string]any{
+			"schemas": g.defs,
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+				"key":    map[string]any{"type": "apiKey", "in": "query", "name": "key"},
+			},
+		},
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+type tsGen struct {
+	seen  map[string]bool
+	decls bytes.Buffer
+}
+
+func (g *tsGen) typ(t reflect.Type) string {
+	if t == rawMessageType {
+		return "unknown"
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.typ(t.Elem())
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice:
+		return g.typ(t.Elem()) + "[] | null"
+	case reflect.Map:
+		return "Record<string, " + g.typ(t.Elem()) + "> | null"
+	case reflect.Struct:
+		name := t.Name()
+		if name == "" && t.NumField() == 0 {
+			return "Record<string, never>"
+		}
+		if !g.seen[name] {
+			g.seen[name] = true
+
+			var body strings.Builder
+			for _, f := range jsonFields(t) {
+				opt := ""
+				if f.optional {
+					opt = "?"
+				}
+				fmt.Fprintf(&body, "  %s%s: %s;\n", f.name, opt, g.typ(f.typ))
+			}
+			fmt.Fprintf(&g.decls, "export interface %s {\n%s}\n\n", name, body.String())
+		}
+		return name
+	}
+	panic(fmt.Sprintf("no TypeScript type for %s", t))
+}
+
+// protocolTypeScript declares the server messages for the widget.
+func protocolTypeScript() []byte {
+	g := &tsGen{seen: map[string]bool{}}
+
+	var messages bytes.Buffer
+	var names []string
+	for _, m := range protocolMessages {
+		var payloads []string
+		for _, p := range m.payloads {
+			payloads = append(payloads, g.typ(reflect.TypeOf(p)))
+		}
+
+		fmt.Fprintf(&messages, "export interface %sMessage {\n", m.name)
+		fmt.Fprintf(&messages, "  type: %q;\n", m.kind)
+		switch {
+		case strings.Contains(m.topic, "*"):
+			fmt.Fprintf(&messages, "  topic: `%s`;\n", strings.ReplaceAll(m.topic, "*", "${string}"))
+		case m.topic != "":
+			fmt.Fprintf(&messages, "  topic: %q;\n", m.topic)
+		}
+		if m.kind == MessageResponse {
+			messages.WriteString("  id: string;\n")
+		}
+		fmt.Fprintf(&messages, "  /** %s */\n", seqDescription)
+		messages.WriteString("  seq: number;\n  ts: number;\n")
+		fmt.Fprintf(&messages, "  payload: %s;\n", strings.Join(payloads, " | "))
+		if m.kind == MessageResponse {
+			fmt.Fprintf(&messages, "  error?: %s;\n", g.typ(reflect.TypeOf(RPCError{})))
+		}
+		messages.WriteString("}\n\n")
+
+		names = append(names, m.name+"Message")
+	}
+
+	// the params each command takes, for typing requests
+	var commandParams bytes.Buffer
+	commandParams.WriteString("export interface CommandParams {\n")
+	for _, name := range commandNames() {
+		fmt.Fprintf(&commandParams, "  %q: %s;\n", name, g.typ(commands[name].params))
+	}
+	commandParams.WriteString("}\n\n")
+	commandParams.WriteString("export interface Request<M extends keyof CommandParams = keyof CommandParams> {\n  id: string;\n  method: M;\n  params?: CommandParams[M];\n}\n")
+
+	var out bytes.Buffer
+	out.WriteString("// Code generated by `zbserv protocol`. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&out, "export const PROTOCOL_VERSION = %d;\n\n", ProtocolVersion)
+	out.Write(g.decls.Bytes())
+	out.Write(messages.Bytes())
+	fmt.Fprintf(&out, "export type ServerMessage =\n  | %s;\n\n", strings.Join(names, "\n  | "))
+	out.Write(commandParams.Bytes())
+	return out.Bytes()
+}
+
+func runProtocolCommand(args []string) int {
+	fs := flag.NewFlagSet("protocol", flag.ExitOnError)
+	schemaPath := fs.String("schema", "", "file to write the JSON Schema to")
+	tsPath := fs.String("ts", "", "file to write the TypeScript types to")
+	openAPIPath := fs.String("openapi", "", "file to write the OpenAPI document to")
+	fs.Parse(args)
+
+	if *schemaPath == "" && *tsPath == "" && *openAPIPath == "" {
+		fs.Usage()
+		return 2
+	}
+
+	if *schemaPath != "" {
+		schema, err := protocolSchema()
+		if err == nil {
+			err = os.WriteFile(*schemaPath, schema, 0o644)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if *openAPIPath != "" {
+		doc, err := openAPIDocument()
+		if err == nil {
+			err = os.WriteFile(*openAPIPath, doc, 0o644)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if *tsPath != "" {
+		if err := os.WriteFile(*tsPath, protocolTypeScript(), 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// SecretProvider resolves a secret such as the HoYoLAB cookie. conf.env can
+// point any secret at a provider with a <NAME>_SOURCE entry:
+//
+//	HOYOLAB_COOKIE_SOURCE=env:HOYOLAB_COOKIE
+//	HOYOLAB_COOKIE_SOURCE=file:C:\secrets\hoyolab.txt
+//	HOYOLAB_COOKIE_SOURCE=cmd:pass show hoyolab/cookie
+//	HOYOLAB_COOKIE_SOURCE=enc:C:\secrets\hoyolab.enc
+type SecretProvider interface {
+	Secret() (string, error)
+}
+
+type envSecret struct{ name string }
+
+func (s envSecret) Secret() (string, error) {
+	v, ok := os.LookupEnv(s.name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", s.name)
+	}
+	return strings.TrimSpace(v), nil
+}
+
+type fileSecret struct{ path string }
+
+func (s fileSecret) Secret() (string, error) {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// commandSecret runs a password manager such as pass or secret-tool and
+// uses its stdout.
+type commandSecret struct{ args []string }
+
+func (s commandSecret) Secret() (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(s.args[0], s.args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %w: %s", s.args[0], err, redact(strings.TrimSpace(stderr.String())))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// encryptedSecret is a file written by `zbserv encrypt-secret`, sealed with
+// AES-GCM under a key derived from a passphrase.
+type encryptedSecret struct {
+	path       string
+	passphrase func() (string, error)
+}
+
+const (
+	encryptedMagic = "zbs1"
+	pbkdf2Rounds   = 600_000
+	saltSize       = 16
+)
+
+var errBadPassphrase = errors.New("wrong passphrase or corrupted secret file")
+
+func (s encryptedSecret) Secret() (string, error) {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return "", err
+	}
+	passphrase, err := s.passphrase()
+	if err != nil {
+		return "", err
+	}
+	plain, err := decryptSecret(b, passphrase)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", s.path, err)
+	}
+	return string(plain), nil
+}
+
+func secretCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, pbkdf2Rounds, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptSecret seals plain as magic | salt | nonce | ciphertext.
+func encryptSecret(plain []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := secretCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte(encryptedMagic), salt...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plain, []byte(encryptedMagic)), nil
+}
+
+func decryptSecret(data []byte, passphrase string) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(encryptedMagic)) || len(data) < len(encryptedMagic)+saltSize {
+		return nil, errors.New("not an encrypted secret file")
+	}
+	data = data[len(encryptedMagic):]
+
+	aead, err := secretCipher(passphrase, data[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	data = data[saltSize:]
+	if len(data) < aead.NonceSize() {
+		return nil, errBadPassphrase
+	}
+
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(encryptedMagic))
+	if err != nil {
+		return nil, errBadPassphrase
+	}
+	return plain, nil
+}
+
+var (
+	passphraseOnce  sync.Once
+	passphraseValue string
+	passphraseErr   error
+)
+
+// readPassphrase takes the passphrase from ZBSERV_PASSPHRASE, or asks for
+// it once on stdin.
+func readPassphrase() (string, error) {
+	passphraseOnce.Do(func() {
+		if v, ok := os.LookupEnv("ZBSERV_PASSPHRASE"); ok {
+			passphraseValue = v
+			return
+		}
+		if stdinIsTerminal() {
+			passphraseValue, passphraseErr = readHidden("passphrase: ")
+			return
+		}
+		fmt.Fprint(os.Stderr, "passphrase: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			passphraseErr = fmt.Errorf("reading passphrase: %w", err)
+			return
+		}
+		passphraseValue = strings.TrimRight(line, "\r\n")
+	})
+	return passphraseValue, passphraseErr
+}
+
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// readHidden prompts on the terminal without echoing what is typed.
+func readHidden(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	b, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("reading %s%w", prompt, err)
+	}
+	return string(b), nil
+}
+
+func ParseSecretProvider(source string) (SecretProvider, error) {
+	kind, arg, ok := strings.Cut(source, ":")
+	if !ok || arg == "" {
+		return nil, fmt.Errorf("invalid secret source %q, want env:, file:, cmd: or enc:", source)
+	}
+
+	switch kind {
+	case "env":
+		return envSecret{name: arg}, nil
+	case "file":
+		return fileSecret{path: arg}, nil
+	case "cmd":
+		args := strings.Fields(arg)
+		return commandSecret{args: args}, nil
+	case "enc":
+		return encryptedSecret{path: arg, passphrase: readPassphrase}, nil
+	}
+	return nil, fmt.Errorf("unknown secret source %q", kind)
+}
+
+// sealer protects a secret zbserv writes to its state directory.
+type sealer interface {
+	seal(plain []byte) ([]byte, error)
+	open(sealed []byte) ([]byte, error)
+}
+
+// plainSealer is for secrets conf.env holds in plain text anyway.
+type plainSealer struct{}
+
+func (plainSealer) seal(plain []byte) ([]byte, error)  { return plain, nil }
+func (plainSealer) open(sealed []byte) ([]byte, error) { return sealed, nil }
+
+// passphraseSealer encrypts the way `zbserv encrypt-secret` does.
+type passphraseSealer struct{ passphrase func() (string, error) }
+
+func (s passphraseSealer) seal(plain []byte) ([]byte, error) {
+	passphrase, err := s.passphrase()
+	if err != nil {
+		return nil, err
+	}
+	return encryptSecret(plain, passphrase)
+}
+
+func (s passphraseSealer) open(sealed []byte) ([]byte, error) {
+	passphrase, err := s.passphrase()
+	if err != nil {
+		return nil, err
+	}
+	return decryptSecret(sealed, passphrase)
+}
+
+// secretSealer is how something derived from the secret name may be written
+// to disk, so it is kept no weaker than the secret itself: as is when
+// conf.env holds the secret in plain text, encrypted under the same
+// passphrase when it comes from an enc: file and not at all otherwise.
+func secretSealer(env map[string]string, name string) sealer {
+	source, ok := env[name+"_SOURCE"]
+	if !ok {
+		return plainSealer{}
+	}
+	provider, err := ParseSecretProvider(source)
+	if err != nil {
+		return nil
+	}
+	if enc, ok := provider.(encryptedSecret); ok {
+		return passphraseSealer{passphrase: enc.passphrase}
+	}
+	return nil
+}
+
+// lookupSecret reads name from conf.env, going through its provider when a
+// <name>_SOURCE entry is present. Every value found is registered for
+// redaction.
+func lookupSecret(env map[string]string, name string) (string, error) {
+	value := env[name]
+	if source, ok := env[name+"_SOURCE"]; ok {
+		provider, err := ParseSecretProvider(source)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+		if value, err = provider.Secret(); err != nil {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	registerSecret(value)
+	return value, nil
+}
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+
+	// cookie and token values HoYoLAB hands out, in case one shows up that
+	// was never registered such as a freshly refreshed cookie
+	secretPattern = regexp.MustCompile(`(?i)\b((?:ltoken|ltuid|ltmid|cookie_token|account_id|account_mid|stoken|mid|login_ticket)(?:_v2)?)=[^;\s"&]+`)
+)
+
+func registerSecret(value string) {
+	// anything shorter would redact unrelated log text, loadAuth refuses keys
+	// that short
+	if len(value) < 8 {
+		return
+	}
+
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+
+	secrets = append(secrets, value)
+	// split cookies so a single token quoted on its own is caught too
+	for _, part := range strings.Split(value, ";") {
+		if _, v, ok := strings.Cut(strings.TrimSpace(part), "="); ok && len(v) >= 8 {
+			secrets = append(secrets, v)
+		}
+	}
+}
+
+func redact(s string) string {
+	secretsMu.RLock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, "[redacted]")
+	}
+	secretsMu.RUnlock()
+
+	return secretPattern.ReplaceAllString(s, "$1=[redacted]")
+}
+
+// redactError is what gets shown to clients in place of err.Error().
+func redactError(err error) string {
+	return redact(err.Error())
+}
+
+// redactWriter is installed as the log output so secrets never reach it.
+type redactWriter struct{ w io.Writer }
+
+func (r redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func runEncryptSecretCommand(args []string) int {
+	fs := flag.NewFlagSet("encrypt-secret", flag.ExitOnError)
+	out := fs.String("out", "", "file to write the encrypted secret to")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: zbserv encrypt-secret -out <file> < secret.txt")
+		fmt.Fprintln(fs.Output(), "the passphrase is read from ZBSERV_PASSPHRASE, run from a terminal")
+		fmt.Fprintln(fs.Output(), "without it both the passphrase and the secret are asked for")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	// stdin carries the secret when piped, so it can only be prompted for
+	// on a terminal
+	_, fromEnv := os.LookupEnv("ZBSERV_PASSPHRASE")
+	if *out == "" || (!fromEnv && !stdinIsTerminal()) {
+		fs.Usage()
+		return 2
+	}
+	passphrase, err := readPassphrase()
+	if err == nil && passphrase == "" {
+		err = errors.New("empty passphrase")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var plain []byte
+	if stdinIsTerminal() {
+		secret, err := readHidden("secret: ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		plain = []byte(secret)
+	} else if plain, err = io.ReadAll(os.Stdin); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	sealed, err := encryptSecret(bytes.TrimSpace(plain), passphrase)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := os.WriteFile(*out, sealed, 0o600); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
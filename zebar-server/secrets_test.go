@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileAndEnvSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookie.txt")
+	os.WriteFile(path, []byte("ltoken_v2=from-file\n"), 0o600)
+	t.Setenv("ZBSERV_TEST_COOKIE", "ltoken_v2=from-env")
+
+	tests := map[string]string{
+		"file:" + path:           "ltoken_v2=from-file",
+		"env:ZBSERV_TEST_COOKIE": "ltoken_v2=from-env",
+	}
+	for source, want := range tests {
+		provider, err := ParseSecretProvider(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := provider.Secret()
+		if err != nil {
+			t.Errorf("%s: %v", source, err)
+		}
+		if got != want {
+			t.Errorf("%s = %q, want %q", source, got, want)
+		}
+	}
+
+	for _, source := range []string{"", "vault:cookie", "file:", "env:ZBSERV_TEST_MISSING"} {
+		provider, err := ParseSecretProvider(source)
+		if err == nil {
+			_, err = provider.Secret()
+		}
+		if err == nil {
+			t.Errorf("%q did not fail", source)
+		}
+	}
+}
+
+// TestSecretHelper is the command run by TestCommandSecret.
+func TestSecretHelper(t *testing.T) {
+	if os.Getenv("ZBSERV_SECRET_HELPER") == "" {
+		t.Skip("helper process")
+	}
+	if os.Getenv("ZBSERV_SECRET_HELPER") == "fail" {
+		fmt.Fprint(os.Stderr, "no such entry, ltoken_v2=leaked")
+		os.Exit(1)
+	}
+	fmt.Println("ltoken_v2=from-cmd")
+	os.Exit(0)
+}
+
+func TestCommandSecret(t *testing.T) {
+	provider, err := ParseSecretProvider("cmd:" + os.Args[0] + " -test.run=^TestSecretHelper$")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("ZBSERV_SECRET_HELPER", "ok")
+	got, err := provider.Secret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "ltoken_v2=from-cmd" {
+		t.Errorf("secret = %q", got)
+	}
+
+	t.Setenv("ZBSERV_SECRET_HELPER", "fail")
+	_, err = provider.Secret()
+	if err == nil {
+		t.Fatal("failing command did not return an error")
+	}
+	if !strings.Contains(err.Error(), "no such entry") || strings.Contains(err.Error(), "leaked") {
+		t.Errorf("err = %q", err)
+	}
+}
+
+func TestEncryptedSecret(t *testing.T) {
+	sealed, err := encryptSecret([]byte("ltoken_v2=sealed"), "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(sealed), "sealed") {
+		t.Fatal("secret is stored in plain text")
+	}
+
+	path := filepath.Join(t.TempDir(), "cookie.enc")
+	os.WriteFile(path, sealed, 0o600)
+
+	secret := encryptedSecret{path: path, passphrase: func() (string, error) { return "hunter2", nil }}
+	got, err := secret.Secret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "ltoken_v2=sealed" {
+		t.Errorf("secret = %q", got)
+	}
+
+	secret.passphrase = func() (string, error) { return "hunter3", nil }
+	if _, err := secret.Secret(); !errors.Is(err, errBadPassphrase) {
+		t.Errorf("wrong passphrase err = %v", err)
+	}
+
+	sealed[len(sealed)-1] ^= 1
+	if _, err := decryptSecret(sealed, "hunter2"); !errors.Is(err, errBadPassphrase) {
+		t.Errorf("tampered file err = %v", err)
+	}
+}
+
+func TestLookupSecretSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookie.txt")
+	os.WriteFile(path, []byte("ltoken_v2=abcdef123456; ltuid_v2=42"), 0o600)
+
+	env, err := parseEnv(strings.NewReader("# hoyolab\nHOYOLAB_COOKIE=ignored\nHOYOLAB_COOKIE_SOURCE=file:" + path + "\n\nMIHOYO_COOKIE=plain-cookie-value\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := lookupSecret(env, "HOYOLAB_COOKIE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "ltoken_v2=abcdef123456; ltuid_v2=42" {
+		t.Errorf("HOYOLAB_COOKIE = %q", got)
+	}
+	if got, _ := lookupSecret(env, "MIHOYO_COOKIE"); got != "plain-cookie-value" {
+		t.Errorf("MIHOYO_COOKIE = %q", got)
+	}
+
+	env["HOYOLAB_STOKEN_SOURCE"] = "file:" + filepath.Join(t.TempDir(), "missing")
+	if _, err := lookupSecret(env, "HOYOLAB_STOKEN"); err == nil || !strings.Contains(err.Error(), "HOYOLAB_STOKEN") {
+		t.Errorf("err = %v", err)
+	}
+
+	if _, err := parseEnv(strings.NewReader("HOYOLAB_COOKIE=a\nltoken_v2_pasted_without_key\n")); err == nil || strings.Contains(err.Error(), "pasted") {
+		t.Errorf("parse err = %v", err)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	registerSecret("plain-cookie-value")
+	registerSecret("ltoken_v2=abcdef123456; ltuid_v2=42")
+
+	tests := map[string]string{
+		"Cookie: plain-cookie-value":                   "Cookie: [redacted]",
+		"token abcdef123456 rejected":                  "token [redacted] rejected",
+		"stoken=v2_xyz; mid=0abc123":                   "stoken=[redacted]; mid=[redacted]",
+		`{"cookie_token_v2":"x","ltoken_v2=v2_abc"}`:   `{"cookie_token_v2":"x","ltoken_v2=[redacted]"}`,
+		"genshin /dailyNote failed: -100 Please login": "genshin /dailyNote failed: -100 Please login",
+	}
+	for in, want := range tests {
+		if got := redact(in); got != want {
+			t.Errorf("redact(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprint(redactWriter{w: &out}, "sent plain-cookie-value\n")
+	if out.String() != "sent [redacted]\n" {
+		t.Errorf("log output = %q", out.String())
+	}
+}
+
+func TestSecretSealer(t *testing.T) {
+	env := map[string]string{
+		"PLAIN":      "value",
+		"ENC_SOURCE": "enc:secret.enc",
+		"CMD_SOURCE": "cmd:pass show zbserv",
+	}
+
+	if _, ok := secretSealer(env, "PLAIN").(plainSealer); !ok {
+		t.Error("a plain secret is not kept as is")
+	}
+	if _, ok := secretSealer(env, "ENC").(passphraseSealer); !ok {
+		t.Error("an enc: secret is not encrypted")
+	}
+	if s := secretSealer(env, "CMD"); s != nil {
+		t.Errorf("a cmd: secret is written with %T", s)
+	}
+}
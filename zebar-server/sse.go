@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var eventLogSize = flag.Int("event-log", 256, "how many published events /events keeps for clients resuming with Last-Event-ID")
+
+const sseKeepAlive = 30 * time.Second
+
+type loggedEvent struct {
+	seq   uint64
+	topic string
+	data  []byte
+}
+
+// eventLog is a ring of the most recently published events. dropped is the
+// newest seq that fell off the end, a client that saw nothing after it has
+// missed events and needs a snapshot instead.
+type eventLog struct {
+	events  []loggedEvent
+	next    int
+	full    bool
+	dropped uint64
+}
+
+func newEventLog(size int) *eventLog {
+	return &eventLog{events: make([]loggedEvent, max(size, 1))}
+}
+
+func (l *eventLog) append(e loggedEvent) {
+	if l.full {
+		l.dropped = l.events[l.next].seq
+	}
+	l.events[l.next] = e
+	l.next = (l.next + 1) % len(l.events)
+	l.full = l.full || l.next == 0
+}
+
+// since returns the logged events after seq in order, or false if some of
+// them were already dropped.
+func (l *eventLog) since(seq uint64) ([]loggedEvent, bool) {
+	if seq < l.dropped {
+		return nil, false
+	}
+
+	var events []loggedEvent
+	start, n := 0, l.next
+	if l.full {
+		start, n = l.next, len(l.events)
+	}
+	for i := range n {
+		e := l.events[(start+i)%len(l.events)]
+		if e.seq > seq {
+			events = append(events, e)
+		}
+	}
+	return events, true
+}
+
+func envelopeSeq(data []byte) uint64 {
+	var env struct {
+		Seq uint64 `json:"seq"`
+	}
+	json.Unmarshal(data, &env)
+	return env.Seq
+}
+
+// newSSEClient writes each message as one SSE event carrying the same
+// envelope /ws sends, with the seq as the event id. A nil message is sent as
+// a keep-alive comment.
+func newSSEClient(w http.ResponseWriter, size int) *Client {
+	rc := http.NewResponseController(w)
+	send := func(data []byte) error {
+		rc.SetWriteDeadline(time.Now().Add(*writeTimeout))
+
+		var err error
+		if data == nil {
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		} else if seq := envelopeSeq(data); seq != 0 {
+			_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", seq, data)
+		} else {
+			_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		if err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	// the handler notices Done and returns, which ends the response
+	shut := func(int, string) {}
+	return newClient(send, shut, size)
+}
+
+// Resume adds c with topics and replays what it missed since seq. It reports
+// false, without adding c, when the log no longer reaches back that far.
+func (s *Server) Resume(c *Client, topics []string, seq uint64) (bool, error) {
+	// as with Subscribe, events published meanwhile queue after the replay
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	// an id from before a restart says nothing about what the client has
+	if seq > messageSeq.Load() {
+		return false, nil
+	}
+
+	// topics are set before c is added so nothing published after the
+	// replay is collected can miss it
+	c.mu.Lock()
+	for _, topic := range topics {
+		c.topics[topic] = true
+	}
+	c.mu.Unlock()
+
+	s.mu.Lock()
+	events, ok := s.events.since(seq)
+	if ok {
+		s.clients[c] = true
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		// left for Subscribe to add again along with their snapshots
+		c.mu.Lock()
+		clear(c.topics)
+		c.mu.Unlock()
+		return false, nil
+	}
+	for _, e := range events {
+		if !c.Subscribed(e.topic) {
+			continue
+		}
+		if err := c.enqueue(e.data); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// serveEvents streams the topics in ?topics= (every topic by default) as
+// Server-Sent Events.
+func serveEvents(w http.ResponseWriter, r *http.Request, s *Server) {
+	if _, ok := auth.Authorize(w, r, ScopeRead); !ok {
+		return
+	}
+
+	topics := topicFamilies
+	if q := r.URL.Query().Get("topics"); q != "" {
+		topics = strings.Split(q, ",")
+	}
+	for _, topic := range topics {
+		if !validTopic(topic) {
+			http.Error(w, "unknown topic "+topic, http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	// the hello has no id so it does not move the client's Last-Event-ID.
+	// Nothing else writes to w until the client is added.
+	if data, err := encodeMessage(MessageHello, "", hello()); err == nil {
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+	if err := http.NewResponseController(w).Flush(); err != nil {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// a resumed client gets the missed events queued in one go
+	c := newSSEClient(w, max(*sendQueue, *eventLogSize))
+
+	// w must not be touched once the handler has returned
+	defer func() {
+		c.Close(websocket.CloseGoingAway, "")
+		<-c.stopped
+	}()
+	defer s.Remove(c)
+
+	resumed := false
+	if seq, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+		resumed, err = s.Resume(c, topics, seq)
+		if err != nil {
+			log.Println("events:", err)
+			return
+		}
+	}
+	if !resumed {
+		s.Add(c)
+		if err := s.Subscribe(c, topics); err != nil {
+			log.Println("events:", err)
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-c.Done():
+			return
+		case <-keepAlive.C:
+			if err := c.Write(nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventLog(t *testing.T) {
+	l := newEventLog(3)
+	for seq := range uint64(5) {
+		l.append(loggedEvent{seq: seq + 1})
+	}
+
+	events, ok := l.since(2)
+	if !ok || len(events) != 3 || events[0].seq != 3 || events[2].seq != 5 {
+		t.Errorf("since(2) = %v, %v", events, ok)
+	}
+	if _, ok := l.since(1); ok {
+		t.Error("since(1) resumed although event 2 was dropped")
+	}
+	if events, ok := l.since(5); !ok || len(events) != 0 {
+		t.Errorf("since(5) = %v, %v", events, ok)
+	}
+}
+
+type sseStream struct {
+	t *testing.T
+	r *bufio.Reader
+}
+
+func openEvents(t *testing.T, serv *Server, query, lastEventId string) *sseStream {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveEvents(w, r, serv)
+	}))
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/events?"+query, nil)
+	if lastEventId != "" {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type %q", ct)
+	}
+
+	s := &sseStream{t: t, r: bufio.NewReader(resp.Body)}
+	if _, data := s.next(); !strings.Contains(string(data), `"type":"hello"`) {
+		t.Fatalf("first event = %s, want hello", data)
+	}
+	return s
+}
+
+// next reads one event, skipping comments
+func (s *sseStream) next() (id string, data []byte) {
+	s.t.Helper()
+
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			s.t.Fatalf("reading events: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && data != nil:
+			return id, data
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = []byte(strings.TrimPrefix(line, "data: "))
+		}
+	}
+}
+
+func (s *sseStream) event(topic string) (string, map[string]any) {
+	s.t.Helper()
+
+	id, data := s.next()
+	return id, decodeEvent(s.t, data, topic)
+}
+
+func TestEventsStream(t *testing.T) {
+	serv := NewServer()
+	serv.AddSnapshot("stamina", func(topic string) [][]byte {
+		data, _ := encodeEvent(StaminaTopic(GENSHIN), StaminaPayload{Game: GENSHIN, Curr: 10, Max: 200})
+		return [][]byte{data}
+	})
+
+	events := openEvents(t, serv, "topics=stamina", "")
+	if _, msg := events.event(StaminaTopic(GENSHIN)); msg["curr"] != 10.0 {
+		t.Errorf("snapshot = %v", msg)
+	}
+
+	publishEvent(t, serv, TopicMedia, map[string]string{"title": "song"})
+	publishEvent(t, serv, StaminaTopic(GENSHIN), StaminaPayload{Game: GENSHIN, Curr: 11, Max: 200})
+
+	id, msg := events.event(StaminaTopic(GENSHIN))
+	if msg["curr"] != 11.0 {
+		t.Errorf("stamina = %v", msg)
+	}
+	if id != fmt.Sprint(messageSeq.Load()) {
+		t.Errorf("event id %s, want the envelope seq %d", id, messageSeq.Load())
+	}
+}
+
+func TestEventsResume(t *testing.T) {
+	serv := NewServer()
+	serv.AddSnapshot("stamina", func(topic string) [][]byte {
+		data, _ := encodeEvent(StaminaTopic(GENSHIN), StaminaPayload{Game: GENSHIN, Curr: 0, Max: 200})
+		return [][]byte{data}
+	})
+
+	publishEvent(t, serv, StaminaTopic(GENSHIN), StaminaPayload{Game: GENSHIN, Curr: 1, Max: 200})
+	seen := messageSeq.Load()
+	publishEvent(t, serv, TopicMedia, map[string]string{"title": "song"})
+	publishEvent(t, serv, StaminaTopic(GENSHIN), StaminaPayload{Game: GENSHIN, Curr: 2, Max: 200})
+
+	// only what was missed on the subscribed topics, without a snapshot
+	events := openEvents(t, serv, "topics=stamina", fmt.Sprint(seen))
+	if _, msg := events.event(StaminaTopic(GENSHIN)); msg["curr"] != 2.0 {
+		t.Errorf("replayed = %v", msg)
+	}
+
+	publishEvent(t, serv, StaminaTopic(GENSHIN), StaminaPayload{Game: GENSHIN, Curr: 3, Max: 200})
+	if _, msg := events.event(StaminaTopic(GENSHIN)); msg["curr"] != 3.0 {
+		t.Errorf("live = %v", msg)
+	}
+}
+
+func TestEventsResumeFallsBackToSnapshot(t *testing.T) {
+	serv := NewServer()
+	serv.events = newEventLog(1)
+	serv.AddSnapshot("stamina", func(topic string) [][]byte {
+		data, _ := encodeEvent(StaminaTopic(GENSHIN), StaminaPayload{Game: GENSHIN, Curr: 50, Max: 200})
+		return [][]byte{data}
+	})
+
+	publishEvent(t, serv, StaminaTopic(GENSHIN), StaminaPayload{Game: GENSHIN, Curr: 1, Max: 200})
+	seen := messageSeq.Load()
+	publishEvent(t, serv, StaminaTopic(GENSHIN), StaminaPayload{Game: GENSHIN, Curr: 2, Max: 200})
+	publishEvent(t, serv, StaminaTopic(GENSHIN), StaminaPayload{Game: GENSHIN, Curr: 3, Max: 200})
+
+	for _, lastEventId := range []string{fmt.Sprint(seen), "99999999999", "garbage"} {
+		events := openEvents(t, serv, "topics=stamina", lastEventId)
+		if _, msg := events.event(StaminaTopic(GENSHIN)); msg["curr"] != 50.0 {
+			t.Errorf("Last-Event-ID %s: got %v, want the snapshot", lastEventId, msg)
+		}
+	}
+}
+
+func TestEventsUnknownTopic(t *testing.T) {
+	rec := httptest.NewRecorder()
+	serveEvents(rec, httptest.NewRequest("GET", "/events?topics=stamina,weather", nil), NewServer())
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d", rec.Code)
+	}
+}
+
+func TestEnvelopeSeq(t *testing.T) {
+	data, _ := encodeEvent(TopicMedia, json.RawMessage(`{}`))
+	if envelopeSeq(data) != messageSeq.Load() {
+		t.Errorf("seq %d, want %d", envelopeSeq(data), messageSeq.Load())
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"strings"
+)
+
+// topicFamilies are the topics a client can subscribe to. Subscribing to a
+// family such as "stamina" covers every game's "stamina.<game>" topic.
+var topicFamilies = []string{"stamina", "checklist", "endgame", TopicMedia, TopicProcess, TopicAlerts, TopicCheckIn, TopicRedeem}
+
+func topicFamily(topic string) string {
+	family, _, _ := strings.Cut(topic, ".")
+	return family
+}
+
+func validTopic(topic string) bool {
+	family, game, scoped := strings.Cut(topic, ".")
+	if !slices.Contains(topicFamilies, family) {
+		return false
+	}
+	if !scoped {
+		return true
+	}
+	_, ok := GameById(game)
+	return ok && (family == "stamina" || family == "checklist" || family == "endgame")
+}
+
+// SnapshotFunc returns the messages describing the current state of topic,
+// which may be a whole family. They are sent to clients as they subscribe.
+type SnapshotFunc func(topic string) [][]byte
+
+func (c *Client) Subscribed(topic string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.topics[topic] || c.topics[topicFamily(topic)]
+}
+
+func (s *Server) AddSnapshot(family string, fn SnapshotFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.snapshots[family] = fn
+}
+
+// Subscribe adds topics to the client and sends it their current state.
+// Unknown topics are rejected without affecting the others.
+func (s *Server) Subscribe(c *Client, topics []string) error {
+	var invalid []string
+
+	// holding the queue lock until the snapshot is queued keeps live events
+	// on the new topics from going out ahead of it
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	var added []string
+	c.mu.Lock()
+	for _, topic := range topics {
+		if !validTopic(topic) {
+			invalid = append(invalid, topic)
+			continue
+		}
+		if !c.topics[topic] && !c.topics[topicFamily(topic)] {
+			added = append(added, topic)
+		}
+		c.topics[topic] = true
+	}
+	c.mu.Unlock()
+
+	for _, topic := range added {
+		s.mu.Lock()
+		snapshot := s.snapshots[topicFamily(topic)]
+		s.mu.Unlock()
+
+		if snapshot == nil {
+			continue
+		}
+		for _, data := range snapshot(topic) {
+			if err := c.enqueue(data); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(invalid) > 0 {
+		return invalidParams("unknown topics %s", strings.Join(invalid, ", "))
+	}
+	return nil
+}
+
+func (s *Server) Unsubscribe(c *Client, topics []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, topic := range topics {
+		delete(c.topics, topic)
+	}
+}
+
+// Publish sends data to every client subscribed to topic and keeps it in
+// the event log for SSE clients resuming later.
+func (s *Server) Publish(topic string, data []byte) {
+	s.mu.Lock()
+	s.events.append(loggedEvent{seq: envelopeSeq(data), topic: topic, data: data})
+	var targets []*Client
+	for c := range s.clients {
+		if c.Subscribed(topic) {
+			targets = append(targets, c)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, c := range targets {
+		if err := c.Write(data); err != nil {
+			log.Println("publish:", err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// connects a websocket client to serv and returns both ends
+func dialServer(t *testing.T, serv *Server) (*Client, *websocket.Conn) {
+	t.Helper()
+
+	clients := make(chan *Client, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		c := NewClient(conn)
+		serv.Add(c)
+		clients <- c
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return <-clients, conn
+}
+
+func readEvent(t *testing.T, conn *websocket.Conn, topic string) map[string]any {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("waiting for %s: %v", topic, err)
+	}
+	return decodeEvent(t, data, topic)
+}
+
+func publishEvent(t *testing.T, serv *Server, topic string, payload any) {
+	t.Helper()
+
+	data, err := encodeEvent(topic, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serv.Publish(topic, data)
+}
+
+func TestPublishOnlyReachesSubscribers(t *testing.T) {
+	serv := NewServer()
+	stamina, staminaConn := dialServer(t, serv)
+	media, mediaConn := dialServer(t, serv)
+
+	if err := serv.Subscribe(stamina, []string{"stamina", TopicMedia}); err != nil {
+		t.Fatal(err)
+	}
+	if err := serv.Subscribe(media, []string{TopicMedia}); err != nil {
+		t.Fatal(err)
+	}
+
+	publishEvent(t, serv, StaminaTopic(GENSHIN), StaminaPayload{Game: GENSHIN, Curr: 100, Max: 200})
+	publishEvent(t, serv, TopicMedia, map[string]string{"title": "song"})
+
+	if msg := readEvent(t, staminaConn, StaminaTopic(GENSHIN)); msg["curr"] != 100.0 {
+		t.Errorf("stamina = %v", msg)
+	}
+	readEvent(t, staminaConn, TopicMedia)
+
+	// the media client skips straight to the media event
+	if msg := readEvent(t, mediaConn, TopicMedia); msg["title"] != "song" {
+		t.Errorf("media = %v", msg)
+	}
+}
+
+func TestSubscribeSendsSnapshot(t *testing.T) {
+	serv := NewServer()
+	client, conn := dialServer(t, serv)
+
+	var asked []string
+	serv.AddSnapshot("stamina", func(topic string) [][]byte {
+		asked = append(asked, topic)
+		data, _ := encodeEvent(StaminaTopic(ZZZ), StaminaPayload{Game: ZZZ, Curr: 180, Max: 240})
+		return [][]byte{data}
+	})
+
+	if err := serv.Subscribe(client, []string{StaminaTopic(ZZZ)}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readEvent(t, conn, StaminaTopic(ZZZ)); msg["curr"] != 180.0 {
+		t.Errorf("snapshot = %v", msg)
+	}
+
+	// a topic already covered does not send the snapshot again
+	if err := serv.Subscribe(client, []string{StaminaTopic(ZZZ)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(asked) != 1 || asked[0] != StaminaTopic(ZZZ) {
+		t.Errorf("snapshots taken for %v", asked)
+	}
+}
+
+func TestSubscribeFamilies(t *testing.T) {
+	serv := NewServer()
+	client, _ := dialServer(t, serv)
+
+	if err := serv.Subscribe(client, []string{"endgame", StaminaTopic(GENSHIN)}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]bool{
+		EndgameTopic(GENSHIN):  true,
+		EndgameTopic(STARRAIL): true,
+		StaminaTopic(GENSHIN):  true,
+		StaminaTopic(ZZZ):      false,
+		ChecklistTopic(ZZZ):    false,
+		TopicMedia:             false,
+	}
+	for topic, want := range tests {
+		if got := client.Subscribed(topic); got != want {
+			t.Errorf("Subscribed(%s) = %v, want %v", topic, got, want)
+		}
+	}
+
+	serv.Unsubscribe(client, []string{"endgame"})
+	if client.Subscribed(EndgameTopic(GENSHIN)) {
+		t.Error("still subscribed to endgame after unsubscribing")
+	}
+}
+
+func TestSubscribeUnknownTopics(t *testing.T) {
+	serv := NewServer()
+	client, _ := dialServer(t, serv)
+
+	err := serv.Subscribe(client, []string{"weather", "media.genshin", "stamina.unknown", TopicAlerts})
+	if err == nil || !strings.Contains(err.Error(), "weather") || !strings.Contains(err.Error(), "stamina.unknown") {
+		t.Errorf("err = %v", err)
+	}
+	if !client.Subscribed(TopicAlerts) {
+		t.Error("valid topic was dropped along with the unknown ones")
+	}
+	if client.Subscribed("media.genshin") {
+		t.Error("subscribed to a per-game topic of an unscoped family")
+	}
+}